@@ -0,0 +1,84 @@
+package scene
+
+import "time"
+
+// NewChild creates a factory for a subsystem (e.g. an admin API or background jobs) that shares this factory's
+// providers and defaults.
+// Scenes created by the child get the parent's defaults and run the parent's providers before the child's own,
+// so a child can override a parent's default. Only the child's own providers are mounted on and unmounted by the child.
+// Children are shut down before their parent drains, a child that was already shut down is skipped.
+// An empty FactoryIdentifier is derived from the parent's.
+func (factory *Factory) NewChild(config Config, injectors ...Provider) (*Factory, error) {
+	if config.FactoryIdentifier == "" {
+		config.FactoryIdentifier = factory.factoryIdentifier + "/child"
+	}
+	factory.childrenLock.Lock()
+	defer factory.childrenLock.Unlock()
+	// Checked under the lock so a child can't be added after the parent collected the children to shut down
+	if factory.closed.Load() {
+		return nil, ErrShutdownInProgress
+	}
+	child := newFactory(config, factory, injectors)
+	factory.children = append(factory.children, child)
+	return child, nil
+}
+
+// Parent gets the factory a child was created from, nil for a root factory.
+func (factory *Factory) Parent() *Factory {
+	return factory.parent
+}
+
+// lineage gets every factory from the root down to this one.
+func (factory *Factory) lineage() []*Factory {
+	if factory.parent == nil {
+		return []*Factory{factory}
+	}
+	return append(factory.parent.lineage(), factory)
+}
+
+// removeChild drops a child that is shutting down on its own.
+func (factory *Factory) removeChild(child *Factory) {
+	factory.childrenLock.Lock()
+	defer factory.childrenLock.Unlock()
+	for k, v := range factory.children {
+		if v == child {
+			factory.children = append(factory.children[:k:k], factory.children[k+1:]...)
+			return
+		}
+	}
+}
+
+// shutdownChildren shuts every child down in parallel, returning their reports in the order they were created.
+// Children drain until the parent's drain deadline instead of getting a full DrainTimeout of their own.
+func (factory *Factory) shutdownChildren(config ShutdownConfig, drainDeadline time.Time) []ShutdownReport {
+	factory.childrenLock.Lock()
+	children := factory.children
+	factory.children = nil
+	factory.childrenLock.Unlock()
+	reports := make([]*ShutdownReport, len(children))
+	done := make(chan struct{}, len(children))
+	for k, child := range children {
+		go func(k int, child *Factory) {
+			defer func() {
+				done <- struct{}{}
+			}()
+			// The child may have started shutting down on its own since the list was collected
+			if child.stopAccepting() {
+				childConfig := config
+				childConfig.DrainTimeout = time.Until(drainDeadline)
+				report := child.shutdownPhases(childConfig, time.Now())
+				reports[k] = &report
+			}
+		}(k, child)
+	}
+	for range children {
+		<-done
+	}
+	var shutdown []ShutdownReport
+	for _, report := range reports {
+		if report != nil {
+			shutdown = append(shutdown, *report)
+		}
+	}
+	return shutdown
+}
@@ -0,0 +1,136 @@
+package scene_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type contextCountProvider struct {
+	scene.BaseProvider
+	name    string
+	created *[]string
+}
+
+func (c contextCountProvider) OnNewContext(ctx scene.Context) {
+	*c.created = append(*c.created, c.name)
+}
+
+func TestFactory_NewChild(t *testing.T) {
+	var created, unmounted []string
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	parent, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Parent",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	}, contextCountProvider{name: "db", created: &created}, unmountProvider{name: "db", order: &unmounted})
+	parent.StoreDefault("shared", "parent")
+	parent.StoreDefault("overridden", "parent")
+	child, err := parent.NewChild(scene.Config{
+		FactoryIdentifier: "Admin",
+		MaxTTL:            time.Millisecond * 20,
+		LogOutput:         logger,
+	}, contextCountProvider{name: "admin", created: &created}, unmountProvider{name: "admin", order: &unmounted})
+	require.NoError(t, err)
+	require.Equal(t, parent, child.Parent())
+	require.Nil(t, parent.Parent())
+	child.StoreDefault("overridden", "child")
+	require.Equal(t, "parent", child.GetDefault("shared"))
+	require.Nil(t, parent.GetDefault("child"))
+
+	ctx, err := child.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, []string{"db", "admin"}, created)
+	require.Equal(t, "parent", ctx.Value("shared"))
+	require.Equal(t, "child", ctx.Value("overridden"))
+	// The child has its own TTL
+	<-ctx.Done()
+	require.ErrorIs(t, ctx.Err(), scene.ErrTimeout)
+	require.Equal(t, 0, parent.OpenContexts())
+
+	parentCtx, err := parent.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, "parent", parentCtx.Value("overridden"))
+	parentCtx.Complete()
+
+	grandchild, err := child.NewChild(scene.Config{LogOutput: logger})
+	require.NoError(t, err)
+	ctx, err = grandchild.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, "child", ctx.Value("overridden"))
+	ctx.Complete()
+
+	// Children are shut down (and their providers unmounted) before the parent
+	report := parent.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Second})
+	require.True(t, report.Clean())
+	require.Equal(t, []string{"admin", "db"}, unmounted)
+	require.Len(t, report.Children, 1)
+	require.Equal(t, "Admin", report.Children[0].FactoryIdentifier)
+	require.Len(t, report.Children[0].Children, 1)
+	require.Equal(t, "Admin/child", report.Children[0].Children[0].FactoryIdentifier)
+	_, err = child.NewCtx()
+	require.ErrorIs(t, err, scene.ErrShutdownInProgress)
+	_, err = parent.NewChild(scene.Config{})
+	require.ErrorIs(t, err, scene.ErrShutdownInProgress)
+}
+
+func TestFactory_ChildShutdown(t *testing.T) {
+	var unmounted []string
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	parent, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Parent",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, unmountProvider{name: "db", order: &unmounted})
+	jobs, err := parent.NewChild(scene.Config{FactoryIdentifier: "Jobs", LogOutput: logger})
+	require.NoError(t, err)
+	failure := errors.New("close failed")
+	api, err := parent.NewChild(scene.Config{FactoryIdentifier: "API", LogOutput: logger}, unmountProvider{name: "api", order: &unmounted, err: failure})
+	require.NoError(t, err)
+
+	// A child shut down on its own is not shut down again
+	require.True(t, jobs.Shutdown(time.Second))
+	report := parent.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Second})
+	require.Len(t, report.Children, 1)
+	require.Equal(t, "API", report.Children[0].FactoryIdentifier)
+	require.False(t, report.Clean())
+	require.Empty(t, report.Failures)
+	require.ErrorIs(t, report.Err(), failure)
+	require.Contains(t, report.Err().Error(), "API: ")
+	require.Equal(t, []string{"api", "db"}, unmounted)
+	require.Equal(t, scene.StateStopped, api.State())
+}
+
+func TestFactory_ChildShutdownSharesDrainDeadline(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	parent, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Parent",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	child, err := parent.NewChild(scene.Config{LogOutput: logger})
+	require.NoError(t, err)
+	grandchild, err := child.NewChild(scene.Config{LogOutput: logger})
+	require.NoError(t, err)
+	// Every factory has a scene that never completes, so each one drains until the deadline
+	for _, factory := range []*scene.Factory{parent, child, grandchild} {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		t.Cleanup(ctx.Complete)
+	}
+	started := time.Now()
+	report := parent.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Millisecond * 100})
+	require.Less(t, time.Since(started), time.Millisecond*250)
+	require.False(t, report.Drained)
+	require.False(t, report.Children[0].Drained)
+	require.False(t, report.Children[0].Children[0].Drained)
+	require.ErrorIs(t, report.Err(), scene.ErrDrainTimeout)
+}
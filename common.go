@@ -48,8 +48,16 @@ type CompleteFunc func(ctx Context, completeErr error)
 // Context extends the go context with a few extra methods required to power all the functionality this looks
 //
 //	to leverage
+//
+// Context gains methods as features land, the newer ones are grouped in the interfaces it embeds.
+// Implementations outside this package (mocks, wrappers) need those methods too, embedding a Context created by a
+// Factory is the simplest way to keep them compiling.
 type Context interface {
 	ogContext.Context
+	ValueViewer
+	ValueRanger
+	Sealer
+	ValueAccessor
 	Store(key, value any)
 	// Attach takes an existing context and attaches it to this Scene
 	Attach(ctx ogContext.Context)
@@ -72,6 +80,46 @@ type Context interface {
 	Extend(until time.Time)
 }
 
+// ValueViewer overrides values of a Scene for part of the code without changing the Scene, views replace the
+// nested contexts of context.WithValue.
+type ValueViewer interface {
+	// With returns a view of the Scene where key resolves to value, the Scene itself is not changed.
+	// The view shares the Scene's lifecycle, Store on the view writes through to the Scene.
+	With(key, value any) Context
+	// Scoped runs fn with a view of the Scene that keeps values stored on it to itself.
+	// Values stored inside fn override the Scene's values for fn only and are discarded once fn returns.
+	Scoped(fn func(ctx Context))
+}
+
+// ValueRanger lists what a Scene holds, which context.Context can't do, to debug or log a Scene.
+// Values of the base context a Scene wraps are not listed.
+type ValueRanger interface {
+	// Range calls fn for every key the Scene resolves, its stored values, values inherited from the Scene it was
+	// spawned from and factory defaults, until fn returns false.
+	Range(fn func(key, value any) bool)
+	// Keys lists every key the Scene resolves, in no particular order.
+	Keys() []any
+}
+
+// Sealer stops code running later in a Scene, such as handlers after middleware, from replacing what setup stored.
+type Sealer interface {
+	// Seal locks the Scene's values once setup is done, afterward only the writable keys can be stored.
+	// Stores of other keys are rejected with ErrSealed, views made by With and Scoped follow the same rule.
+	Seal(writable ...any)
+}
+
+// ValueAccessor works with a Scene's values where Store and Value can't tell what happened, a Store that was rejected
+// and a nil value both look like success or a missing key otherwise.
+type ValueAccessor interface {
+	// TryStore is Store that returns why a value wasn't stored: ErrComplete, ErrReadOnlyKey or ErrSealed.
+	TryStore(key, value any) error
+	// Lookup is Value that tells a stored nil apart from a missing key.
+	Lookup(key any) (any, bool)
+	// Delete removes a value from the Scene, including a factory default.
+	// It can be used in Defer callbacks to release values while the Scene completes.
+	Delete(key any)
+}
+
 // FactoryDefaultValuer allows for full access to the factory's default setup
 type FactoryDefaultValuer interface {
 	// StoreDefault stores a default value in all new Scenes created in this factory for a given value key
@@ -90,7 +138,7 @@ type FactoryStore interface {
 // Provider g
 type Provider interface {
 	// OnFactoryMount is called when the factory runs the initial injector, this is generally only called once per factory
-	// when the application mounts
+	// when the application mounts, Factory.Reload calls it again to refresh the defaults it stores.
 	// While this method provides access to FactoryDefaultValuer.NewCtx() it should not use it, doing so can lead to a data race.
 	// This is needed so that it can be stored for setup purposes for use inside default invocations.
 	OnFactoryMount(valuer FactoryDefaultValuer)
@@ -127,3 +175,11 @@ func (b BaseProvider) OnNewContext(ctx Context) {
 func (b BaseProvider) OnSpawnedContext(ctx Context, parentContext Context) {
 
 }
+
+// getFactory resolves the factory that created a Scene, nil is returned for non-Scene contexts.
+func getFactory(ctx ogContext.Context) *Factory {
+	if c, _, ok := unwrapScene(ctx); ok {
+		return c.factory
+	}
+	return nil
+}
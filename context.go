@@ -2,6 +2,7 @@ package scene
 
 import (
 	ogContext "context"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,8 +22,11 @@ type context struct {
 	id string
 	// A complete channel used for ctx interface requirements
 	complete chan struct{}
-	// Context value map (values are not thread-safe) that stores various metadata about the context
+	// Context value map (values are not thread-safe) that stores various metadata about the context.
+	// Only values stored on this context are kept here, it overlays the factory defaults.
 	contextValues map[any]any
+	// The defaults snapshot of every factory in the lineage when the context was created, root first
+	defaults []map[any]any
 	// is this context marked as completed?
 	isComplete bool
 	// The error that is stored when Complete is invoked
@@ -37,27 +41,52 @@ type context struct {
 	startedBy   string
 	mu          *sync.RWMutex
 	activeTimer *time.Timer
+	// Set when the context opted into a shutdown notice with NotifyShutdown
+	notice *shutdownNotice
+	// LazyValue entries that were looked up, allocated on first use
+	lazy map[any]*lazyEntry
+	// Results of Once, allocated on first use
+	onces map[any]*onceEntry
+	// The scene this was spawned from when Config.InheritParentValues is set
+	inheritFrom Context
+	// Keys stored with StoreReadOnly, allocated on first use
+	readOnly map[any]struct{}
+	// Set by Seal, only keys in writable can be stored afterward
+	sealed   bool
+	writable map[any]struct{}
+	// The providers that were mounted when the context was created
+	providers []*mountedProvider
+	// The Context handed out for this context, a pooledContext handle in pooled mode or the context itself
+	self Context
+	// Incremented every time a pooled context is reused
+	generation atomic.Uint64
 }
 
-// refreshDeadline updates the context deadline when called
-func (c *context) refreshDeadline() {
-	var setupMonitor bool
+// refreshDeadline updates the context deadline when called.
+// generation is the generation of the scene that set the deadline, a pooled context may have been reused since.
+func (c *context) refreshDeadline(generation uint64) {
 	c.mu.Lock()
+	if c.isComplete || c.generation.Load() != generation {
+		c.mu.Unlock()
+		return
+	}
 	if c.activeTimer != nil {
 		c.activeTimer.Reset(time.Until(time.Unix(0, c.completeBy)))
-	} else {
-		c.activeTimer = time.NewTimer(time.Until(time.Unix(0, c.completeBy)))
-		setupMonitor = true
-	}
-	c.mu.Unlock()
-	if !setupMonitor {
+		c.mu.Unlock()
 		return
 	}
+	c.activeTimer = time.NewTimer(time.Until(time.Unix(0, c.completeBy)))
+	// Hold onto this generation's channels, a pooled context may be reused once it completes
+	timer, complete := c.activeTimer, c.complete
+	c.mu.Unlock()
 	// Force the context to complete at a specific time, this will close the context and signal everything to stop working
 	// The logging instance is NOT destroyed
 	select {
-	case <-c.activeTimer.C:
-		c.CompleteWithError(stack.Trace(ErrTimeout, stack.ErrorKVP{
+	case <-timer.C:
+		if c.factory.config.DebugMode {
+			c.logTimeout(generation)
+		}
+		c.completeWithError(generation, stack.Trace(ErrTimeout, stack.ErrorKVP{
 			Key:   "startedBy",
 			Value: c.startedBy,
 		}, stack.ErrorKVP{
@@ -71,81 +100,216 @@ func (c *context) refreshDeadline() {
 			Value: c.factory.factoryIdentifier,
 		}))
 		return
-	case <-c.complete:
+	case <-complete:
 		return
 	}
 }
 
+// logTimeout logs the keys of a scene that is about to time out.
+// Only keys are logged since values may hold credentials or personal data, Dump prints the values on demand.
+func (c *context) logTimeout(generation uint64) {
+	c.mu.RLock()
+	if !c.live(generation) {
+		c.mu.RUnlock()
+		return
+	}
+	self := c.self
+	c.mu.RUnlock()
+	c.factory.factoryLogger.Debug().
+		Str("requestID", GetRequestID(self)).
+		Str("startedBy", c.startedBy).
+		Strs("keys", formatKeys(keysOf(c.mergedValues(generation)))).
+		Msg("scene timed out")
+}
+
+// current reports whether the context is still on generation, c.mu must be held.
+// Every operation of a pooled context's handle checks this under the same lock it runs with,
+// so a stale handle can't reach into the scene the context was reused for.
+func (c *context) current(generation uint64) bool {
+	return c.generation.Load() == generation
+}
+
+// live reports whether the context is on generation and hasn't completed, c.mu must be held.
+func (c *context) live(generation uint64) bool {
+	return !c.isComplete && c.current(generation)
+}
+
 func (c *context) Extend(runUntil time.Time) {
+	c.extend(c.generation.Load(), runUntil)
+}
+
+func (c *context) extend(generation uint64, runUntil time.Time) {
 	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.current(generation) {
+		return
+	}
 	deadline := runUntil.Sub(time.Now())
 	c.deadline = deadline
 	c.completeBy = time.Now().Add(deadline).UnixNano()
-	go c.refreshDeadline()
-	c.mu.Unlock()
+	go c.refreshDeadline(generation)
 }
 
 func (c *context) Attach(ctx ogContext.Context) {
-	if c2, ok := ctx.(*context); ok {
-		c.Context = c2.Context
-		return
+	c.attach(c.generation.Load(), ctx)
+}
+
+func (c *context) attach(generation uint64, ctx ogContext.Context) {
+	if c2, _, ok := asScene(ctx); ok {
+		ctx = c2.Context
+	}
+	c.mu.Lock()
+	if c.current(generation) {
+		c.Context = ctx
 	}
-	c.Context = ctx
+	c.mu.Unlock()
 }
 
 func (c *context) Defer(fn CompleteFunc) {
+	c.addDefer(c.generation.Load(), fn)
+}
+
+func (c *context) addDefer(generation uint64, fn CompleteFunc) {
 	c.mu.Lock()
-	c.onComplete = append(c.onComplete, fn)
+	if c.current(generation) {
+		c.onComplete = append(c.onComplete, fn)
+	}
 	c.mu.Unlock()
 }
 
+// deleted marks a key that has a default as removed from the context.
+type deleted struct{}
+
 // Store puts a new value inside the context, the value does not need to be thread-safe (but can be)
 func (c *context) Store(key, value any) {
+	c.storeValue(c.generation.Load(), key, value)
+}
+
+func (c *context) storeValue(generation uint64, key, value any) {
+	if requestID, err := c.store(generation, key, value); err != nil && !errors.Is(err, ErrComplete) {
+		c.rejectStore(requestID, err)
+	}
+}
+
+// TryStore is Store that reports why a value wasn't stored, ErrComplete once the context completed.
+func (c *context) TryStore(key, value any) error {
+	_, err := c.store(c.generation.Load(), key, value)
+	return err
+}
+
+// store returns the request ID with the error so a rejected store can be logged without the lock.
+func (c *context) store(generation uint64, key, value any) (string, error) {
 	c.mu.Lock()
-	if c.isComplete {
+	defer c.mu.Unlock()
+	if !c.live(generation) {
+		return c.id, ErrComplete
+	}
+	if err := c.checkWritable(key); err != nil {
+		return c.id, err
+	}
+	c.contextValues[key] = value
+	return c.id, nil
+}
+
+// Delete removes a value from the context, including a default the context was created with.
+// Unlike Store this works in Defer callbacks, so providers can release their values while the context completes.
+func (c *context) Delete(key any) {
+	c.deleteValue(c.generation.Load(), key)
+}
+
+func (c *context) deleteValue(generation uint64, key any) {
+	c.mu.Lock()
+	if !c.current(generation) {
 		c.mu.Unlock()
 		return
 	}
-	c.contextValues[key] = value
+	requestID, err := c.deleteLocked(key)
 	c.mu.Unlock()
+	if err != nil {
+		c.rejectStore(requestID, err)
+	}
+}
+
+func (c *context) deleteLocked(key any) (string, error) {
+	if c.contextValues == nil {
+		return c.id, nil
+	}
+	if c.isComplete {
+		// Defer callbacks can remove anything but the keys set by the context itself
+		if isReadOnlyKey(key) {
+			return c.id, c.checkWritable(key)
+		}
+	} else if err := c.checkWritable(key); err != nil {
+		return c.id, err
+	}
+	delete(c.lazy, key)
+	for _, defaults := range c.defaults {
+		if _, found := defaults[key]; found {
+			c.contextValues[key] = deleted{}
+			return c.id, nil
+		}
+	}
+	delete(c.contextValues, key)
+	return c.id, nil
 }
 
 func (c *context) GetBaseCtx() ogContext.Context {
+	return c.baseCtx(c.generation.Load())
+}
+
+func (c *context) baseCtx(generation uint64) ogContext.Context {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.current(generation) {
+		return nil
+	}
 	return c.Context
 }
 
 // Spawn a new context that needs to complete by a given time.
 // A zero-value time will produce an infinitely running child context.
 func (c *context) Spawn(completeBy time.Time) (Context, error) {
-	c.mu.Lock()
-	isComplete := c.isComplete
-	c.mu.Unlock()
-	if isComplete {
+	return c.spawn(c.generation.Load(), completeBy)
+}
+
+func (c *context) spawn(generation uint64, completeBy time.Time) (Context, error) {
+	c.mu.RLock()
+	if !c.live(generation) {
+		c.mu.RUnlock()
 		return nil, ErrShutdownInProgress
 	}
+	base, self := c.Context, c.self
+	c.mu.RUnlock()
 	var ttl time.Duration
 	if !completeBy.IsZero() {
 		ttl = time.Until(completeBy)
 	}
-	newCtx := c.factory.newCtx(c.Context, ttl)
+	newCtx := c.factory.newCtx(base, ttl)
+	if c.factory.config.InheritParentValues {
+		newCtx.inheritFrom = self
+	}
 	defer func() {
 		if r := recover(); r != nil {
 			// Complete the context since this can cause issues with a factory being stuck
 			newCtx.Complete()
 		}
 	}()
-	for _, v := range c.factory.injectors {
-		if v != nil {
-			v.OnSpawnedContext(newCtx, c)
-		}
+	for _, v := range newCtx.providers {
+		v.OnSpawnedContext(newCtx.self, self)
 	}
-	return newCtx, nil
+	return newCtx.start(), nil
 }
 
 // Deadline returns a time when the request will be marked as timed out.
 // If ok is set to false, it can be ignored
 func (c *context) Deadline() (deadline time.Time, ok bool) {
-	if c.deadline == 0 {
+	return c.deadlineAt(c.generation.Load())
+}
+
+func (c *context) deadlineAt(generation uint64) (deadline time.Time, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.deadline == 0 || !c.current(generation) {
 		ok = false
 		return
 	}
@@ -154,20 +318,39 @@ func (c *context) Deadline() (deadline time.Time, ok bool) {
 
 // Done returns a completion channel notifying a listener if the context was completed or not
 func (c *context) Done() <-chan struct{} {
+	return c.done(c.generation.Load())
+}
+
+// done gets the completion channel of generation, a reused context's scene is already complete.
+func (c *context) done(generation uint64) <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.current(generation) {
+		return closedChan
+	}
 	return c.complete
 }
 
 // GetLastError Returns the last error for a given context.
 func (c *context) GetLastError() error {
+	err, _ := c.lastError(c.generation.Load())
+	return err
+}
+
+// lastError gets the error of generation, false is returned once the context was reused.
+func (c *context) lastError(generation uint64) (error, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	if !c.current(generation) {
+		return nil, false
+	}
 	if c.err != nil {
-		return c.err
+		return c.err, true
 	}
 	if c.Context != nil {
-		return c.Context.Err()
+		return c.Context.Err(), true
 	}
-	return nil
+	return nil, true
 }
 
 // Err is the context override for GetLastError
@@ -176,18 +359,98 @@ func (c *context) Err() error {
 }
 
 // Value will get an item from the context if found, otherwise will navigate through any child context(s) if applicable.
+// LazyValue entries are built on first access.
 func (c *context) Value(key any) any {
+	val, _ := c.Lookup(key)
+	return val
+}
+
+// Lookup is Value that tells a stored nil apart from a missing key.
+func (c *context) Lookup(key any) (any, bool) {
+	return c.lookupValue(c.generation.Load(), key)
+}
+
+func (c *context) lookupValue(generation uint64, key any) (any, bool) {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if c.contextValues != nil {
-		if val, found := c.contextValues[key]; found {
-			return val
+	if !c.current(generation) {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	val, found, hidden := c.storedValue(key)
+	fallback, hasDefault := c.defaultValue(key)
+	base, parent := c.Context, c.inheritFrom
+	c.mu.RUnlock()
+	// Values set on the scene this was spawned from override the defaults, a deleted key hides both
+	if !found && !hidden {
+		if parent != nil && c.factory.inherits(key) {
+			val, found = inheritedValue(parent, key)
+		}
+		if !found {
+			val, found = fallback, hasDefault
 		}
 	}
-	if c.Context != nil {
-		return c.Context.Value(key)
+	if !found {
+		if base != nil {
+			if val := base.Value(key); val != nil {
+				return val, true
+			}
+		}
+		return nil, false
+	}
+	if build, ok := val.(LazyValue); ok {
+		val, err := c.resolveLazy(generation, key, build)
+		return val, err == nil
 	}
-	return nil
+	return val, true
+}
+
+// storedValue finds a value stored on the context, hidden is set for keys removed with Delete. c.mu must be held.
+func (c *context) storedValue(key any) (val any, found, hidden bool) {
+	if c.contextValues == nil {
+		return nil, false, false
+	}
+	val, found = c.contextValues[key]
+	if _, isDeleted := val.(deleted); isDeleted {
+		return nil, false, true
+	}
+	return val, found, false
+}
+
+// defaultValue finds a value in the defaults the context was created with, c.mu must be held.
+func (c *context) defaultValue(key any) (any, bool) {
+	// A child factory's defaults take priority over its parent's
+	for k := len(c.defaults) - 1; k >= 0; k-- {
+		if val, found := c.defaults[k][key]; found {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// inheritedValue finds a value stored on the scene a context was spawned from, or on the scenes that one inherits from.
+// Defaults are left out since the spawned scene has its own, and so are lazily built values so every scene builds
+// its own.
+func inheritedValue(parent Context, key any) (any, bool) {
+	p, generation, ok := asScene(parent)
+	if !ok {
+		return nil, false
+	}
+	p.mu.RLock()
+	if !p.current(generation) {
+		p.mu.RUnlock()
+		return nil, false
+	}
+	val, found, hidden := p.storedValue(key)
+	_, built := p.lazy[key]
+	grandparent := p.inheritFrom
+	p.mu.RUnlock()
+	if found && !built {
+		return val, true
+	}
+	if hidden || grandparent == nil || !p.factory.inherits(key) {
+		return nil, false
+	}
+	return inheritedValue(grandparent, key)
 }
 
 // Complete a context, this sets a special error as the go implementation of context requires closed context's to have
@@ -198,38 +461,68 @@ func (c *context) Complete() {
 
 // CompleteWithError finishes an open context with a specific error, if the error is nil it will finish with ErrComplete
 func (c *context) CompleteWithError(err error) {
+	c.completeWithError(c.generation.Load(), err)
+}
+
+// completeWithError completes the context if it is still on generation, so timers and stale handles of a pooled
+// context can't complete the scene it was reused for.
+func (c *context) completeWithError(generation uint64, err error) {
 	// Ensure this doesn't "complete" twice
 	c.mu.Lock()
-	if c.isComplete {
+	if c.isComplete || c.generation.Load() != generation {
 		c.mu.Unlock()
 		return
 	}
 	c.isComplete = true
-	// The lock is not held longer than to set isComplete.
-	// New values can no longer be pushed to onComplete once this flag is set.
-	// onComplete methods can access stored variables which cause a read lock.
-	c.mu.Unlock()
+	// The error is set with isComplete, so callers that see the context complete also see why
 	if err != nil {
 		c.err = err
 	}
-	atomic.StoreInt64(&c.completeBy, time.Now().UnixNano())
-	atomic.AddInt32(&c.factory.openContexts, -1)
-	c.factory.openContextWg.Done()
 	if c.err == nil {
 		c.err = ErrComplete
 	}
+	if c.notice != nil && c.notice.timer != nil {
+		c.notice.timer.Stop()
+	}
+	// The lock is not held longer than to set isComplete.
+	// New values can no longer be pushed to onComplete once this flag is set.
+	// onComplete methods can access stored variables which cause a read lock.
+	c.mu.Unlock()
+	atomic.StoreInt64(&c.completeBy, time.Now().UnixNano())
+	c.factory.openLock.Lock()
+	delete(c.factory.open, c)
+	c.factory.openLock.Unlock()
+	// The context only stops counting as open once its Defer callbacks have run.
+	// This keeps factory shutdowns from unmounting providers that a callback is still releasing resources to.
+	var values map[any]any
+	defer func() {
+		for _, v := range c.providers {
+			v.release()
+		}
+		factory := c.factory
+		if factory.config.PoolContexts {
+			factory.recycle(c, values)
+		}
+		atomic.AddInt32(&factory.openContexts, -1)
+		factory.openContextWg.Done()
+	}()
 	// Do this as a LIFO queue
 	// This section needs to be unlocked to allow these methods to access context variables
 	for i := len(c.onComplete) - 1; i >= 0; i-- {
-		c.onComplete[i](c, err)
+		c.onComplete[i](c.self, err)
 	}
 	c.mu.Lock()
 	close(c.complete)
 	// Clear out all references in the context values.
 	// It is possible for a cyclical reference to be placed in the context leading to a subtle memory leak.
-	for k := range c.contextValues {
-		delete(c.contextValues, k)
-	}
+	clear(c.contextValues)
+	values = c.contextValues
 	c.contextValues = nil
+	c.defaults = nil
+	c.lazy = nil
+	c.onces = nil
+	c.inheritFrom = nil
+	c.readOnly = nil
+	c.writable = nil
 	c.mu.Unlock()
 }
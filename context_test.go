@@ -380,3 +380,140 @@ func TestContext_Store(t *testing.T) {
 	require.Equal(t, nil, ctx.Value("test"))
 
 }
+
+func TestContext_SpawnInheritsParentValues(t *testing.T) {
+	newFactory := func(config scene.Config) *scene.Factory {
+		buf := tsbuffer.New()
+		config.LogOutput = zerolog.New(buf)
+		config.MaxTTL = scene.NoTTL
+		factory, _ := scene.NewSceneFactory(config)
+		t.Cleanup(func() {
+			require.True(t, factory.Shutdown(time.Second))
+		})
+		return factory
+	}
+	t.Run("disabled", func(t *testing.T) {
+		factory := newFactory(scene.Config{})
+		ctx, _ := factory.NewCtx()
+		defer ctx.Complete()
+		child, err := ctx.Spawn(scene.RunForever)
+		require.NoError(t, err)
+		defer child.Complete()
+		ctx.Store(testKey, "parent")
+		require.Nil(t, child.Value(testKey))
+	})
+	t.Run("every key", func(t *testing.T) {
+		factory := newFactory(scene.Config{InheritParentValues: true})
+		ctx, _ := factory.NewCtx()
+		defer ctx.Complete()
+		child, err := ctx.Spawn(scene.RunForever)
+		require.NoError(t, err)
+		defer child.Complete()
+		grandchild, err := child.Spawn(scene.RunForever)
+		require.NoError(t, err)
+		defer grandchild.Complete()
+		// Values stored on the parent after the spawn are visible
+		ctx.Store(testKey, "parent")
+		require.Equal(t, "parent", child.Value(testKey))
+		require.Equal(t, "parent", grandchild.Value(testKey))
+		child.Store(testKey, "child")
+		require.Equal(t, "child", grandchild.Value(testKey))
+		require.Equal(t, "parent", ctx.Value(testKey))
+		require.NotEqual(t, scene.GetRequestID(ctx), scene.GetRequestID(child))
+	})
+	t.Run("allow-list", func(t *testing.T) {
+		factory := newFactory(scene.Config{InheritParentValues: true, InheritKeys: []any{testKey}})
+		ctx, _ := factory.NewCtx()
+		defer ctx.Complete()
+		child, err := ctx.Spawn(scene.RunForever)
+		require.NoError(t, err)
+		defer child.Complete()
+		ctx.Store(testKey, "inherited")
+		ctx.Store(testKey2, "private")
+		require.Equal(t, "inherited", child.Value(testKey))
+		require.Nil(t, child.Value(testKey2))
+		// The parent completing stops the inheritance
+		ctx.Complete()
+		require.Nil(t, child.Value(testKey))
+	})
+	t.Run("overridden defaults", func(t *testing.T) {
+		factory := newFactory(scene.Config{InheritParentValues: true})
+		factory.StoreDefault(testKey, "default")
+		factory.StoreDefault(testKey2, "default")
+		ctx, _ := factory.NewCtx()
+		defer ctx.Complete()
+		child, err := ctx.Spawn(scene.RunForever)
+		require.NoError(t, err)
+		defer child.Complete()
+		// A value the parent set overrides the child's default, keys the parent didn't set keep the default
+		ctx.Store(testKey, "parent")
+		require.Equal(t, "parent", child.Value(testKey))
+		require.Equal(t, "default", child.Value(testKey2))
+		child.Store(testKey, "child")
+		require.Equal(t, "child", child.Value(testKey))
+		// A key deleted on the child hides both the parent's value and the default
+		child.Delete(testKey)
+		_, found := child.Lookup(testKey)
+		require.False(t, found)
+		// Deleting on the parent only drops the parent's value
+		ctx.Delete(testKey2)
+		require.Equal(t, "default", child.Value(testKey2))
+	})
+}
+
+func TestContext_TryStoreLookupDelete(t *testing.T) {
+	t.Parallel()
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+		PoolContexts:      true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault(testKey2, "default")
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+
+	require.NoError(t, ctx.TryStore(testKey, nil))
+	val, found := ctx.Lookup(testKey)
+	require.True(t, found)
+	require.Nil(t, val)
+	_, found = ctx.Lookup("missing")
+	require.False(t, found)
+	require.ErrorIs(t, ctx.TryStore(scene.RequestIDKey{}, "forged"), scene.ErrReadOnlyKey)
+
+	// Deleting a default hides it from the scene only
+	ctx.Delete(testKey2)
+	_, found = ctx.Lookup(testKey2)
+	require.False(t, found)
+	require.NotContains(t, ctx.Keys(), testKey2)
+	require.Equal(t, "default", factory.GetDefault(testKey2))
+	ctx.Store(testKey2, "stored")
+	require.Equal(t, "stored", ctx.Value(testKey2))
+
+	ctx.Scoped(func(scoped scene.Context) {
+		scoped.Delete(testKey2)
+		_, found := scoped.Lookup(testKey2)
+		require.False(t, found)
+	})
+	require.Equal(t, "stored", ctx.Value(testKey2))
+
+	ctx.Store(testKey, "resource")
+	ctx.Defer(func(ctx scene.Context, completeErr error) {
+		// Stores are ignored once the scene completes, deletes are not
+		require.ErrorIs(t, ctx.TryStore(testKey, nil), scene.ErrComplete)
+		ctx.Delete(testKey)
+		_, found := ctx.Lookup(testKey)
+		require.False(t, found)
+		ctx.Delete(scene.RequestIDKey{})
+		require.NotEmpty(t, scene.GetRequestID(ctx))
+	})
+	ctx.Complete()
+	require.ErrorIs(t, ctx.TryStore(testKey, "late"), scene.ErrComplete)
+	_, found = ctx.Lookup(testKey)
+	require.False(t, found)
+}
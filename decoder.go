@@ -0,0 +1,88 @@
+package scene
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrMalformedRequestBody = errors.New("malformed request body")
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// RequestDecoder is the request side counterpart to ResponseEncoder.
+type RequestDecoder interface {
+	// Decode reads the request body into obj.
+	// Errors should be a *DecodeError so the correct status code is reported to the client.
+	Decode(obj any) error
+}
+
+// DecoderProvider allows for the correct decoder to be returned based on the provided http request.
+// This is generally chosen from the Content-Type header.
+type DecoderProvider func(ctx Context, request *http.Request) RequestDecoder
+
+// DecodeError carries the HTTP status code a decoding failure should be reported with.
+type DecodeError struct {
+	// Kind is one of ErrMalformedRequestBody, ErrRequestBodyTooLarge or ErrUnsupportedMediaType
+	Kind       error
+	Err        error
+	StatusCode int
+}
+
+// NewDecodeError creates a new decode error for a given kind, the status code is derived from the kind.
+func NewDecodeError(kind error, err error) *DecodeError {
+	statusCode := http.StatusBadRequest
+	switch kind {
+	case ErrRequestBodyTooLarge:
+		statusCode = http.StatusRequestEntityTooLarge
+	case ErrUnsupportedMediaType:
+		statusCode = http.StatusUnsupportedMediaType
+	}
+	return &DecodeError{
+		Kind:       kind,
+		Err:        err,
+		StatusCode: statusCode,
+	}
+}
+
+func (d *DecodeError) Error() string {
+	if d.Err == nil {
+		return d.Kind.Error()
+	}
+	return d.Kind.Error() + ": " + d.Err.Error()
+}
+
+func (d *DecodeError) Unwrap() []error {
+	return []error{d.Kind, d.Err}
+}
+
+type emptyDecoder struct{}
+
+func (e emptyDecoder) Decode(obj any) error {
+	return NewDecodeError(ErrUnsupportedMediaType, errors.New("no decoder is available for this request"))
+}
+
+// GetDecoder gets the request decoder for a scene created by the HTTP middleware.
+// If no decoder is present, the returned decoder will fail with ErrUnsupportedMediaType.
+func GetDecoder(ctx Context) RequestDecoder {
+	val := ctx.Value(CtxHTTPDecoder{})
+	if val == nil {
+		return emptyDecoder{}
+	}
+	return val.(RequestDecoder)
+}
+
+// DecodeRequest decodes the request body into obj.
+// Any error is added to the scene's encoder with a matching status code (400, 413 or 415) before being returned.
+func DecodeRequest(ctx Context, obj any) error {
+	err := GetDecoder(ctx).Decode(obj)
+	if err == nil {
+		return nil
+	}
+	statusCode := http.StatusBadRequest
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		statusCode = decodeErr.StatusCode
+	}
+	GetEncoder(ctx).AddError(err, statusCode)
+	return err
+}
@@ -0,0 +1,120 @@
+package scene_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/scene/encoders"
+)
+
+type decodeTarget struct {
+	Name  string   `json:"name" xml:"name" form:"name"`
+	Count int      `json:"count" xml:"count" form:"count"`
+	Tags  []string `json:"tags" xml:"tags" form:"tag"`
+}
+
+func TestDecodeRequest(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	serve := func(t *testing.T, contentType string, body string, options ...scene.HTTPOption) (decodeTarget, error, *httptest.ResponseRecorder) {
+		var target decodeTarget
+		var decodeErr error
+		middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+			return encoders.NewJSONEncoder(request.Header, testWrapper{})
+		}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {}, options...)
+		require.NoError(t, err)
+		middleware.Next(testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+			ctx := scene.GetScene(r.Context())
+			decodeErr = scene.DecodeRequest(ctx, &target)
+			_ = scene.GetEncoder(ctx).Encode(nil)
+		}})
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		middleware.ServeHTTP(recorder, req)
+		return target, decodeErr, recorder
+	}
+	provider := scene.WithDecoderProvider(encoders.NewContentTypeDecoderProvider(encoders.DecoderOptions{}))
+	strictProvider := scene.WithDecoderProvider(encoders.NewContentTypeDecoderProvider(encoders.DecoderOptions{Strict: true}))
+	t.Run("json", func(t *testing.T) {
+		target, err, recorder := serve(t, "application/json; charset=utf-8", `{"name":"foo","count":2,"tags":["a","b"],"extra":true}`, provider)
+		require.NoError(t, err)
+		require.Equal(t, decodeTarget{Name: "foo", Count: 2, Tags: []string{"a", "b"}}, target)
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+	t.Run("json strict", func(t *testing.T) {
+		_, err, recorder := serve(t, "application/vnd.api+json", `{"name":"foo","extra":true}`, strictProvider)
+		require.ErrorIs(t, err, scene.ErrMalformedRequestBody)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+	t.Run("json trailing data", func(t *testing.T) {
+		_, err, _ := serve(t, "application/json", `{"name":"foo"}{"name":"bar"}`, provider)
+		require.ErrorIs(t, err, scene.ErrMalformedRequestBody)
+	})
+	t.Run("xml", func(t *testing.T) {
+		target, err, _ := serve(t, "application/xml", `<decodeTarget><name>foo</name><count>3</count><tags>a</tags></decodeTarget>`, provider)
+		require.NoError(t, err)
+		require.Equal(t, decodeTarget{Name: "foo", Count: 3, Tags: []string{"a"}}, target)
+	})
+	t.Run("form", func(t *testing.T) {
+		form := url.Values{"name": {"foo"}, "count": {"4"}, "tag": {"a", "b"}}
+		target, err, _ := serve(t, "application/x-www-form-urlencoded", form.Encode(), provider)
+		require.NoError(t, err)
+		require.Equal(t, decodeTarget{Name: "foo", Count: 4, Tags: []string{"a", "b"}}, target)
+	})
+	t.Run("form strict", func(t *testing.T) {
+		form := url.Values{"name": {"foo"}, "unknown": {"1"}}
+		_, err, recorder := serve(t, "application/x-www-form-urlencoded", form.Encode(), strictProvider)
+		require.ErrorIs(t, err, scene.ErrMalformedRequestBody)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+	t.Run("form invalid value", func(t *testing.T) {
+		form := url.Values{"count": {"banana"}}
+		_, err, _ := serve(t, "application/x-www-form-urlencoded", form.Encode(), provider)
+		require.ErrorIs(t, err, scene.ErrMalformedRequestBody)
+	})
+	t.Run("body too large", func(t *testing.T) {
+		_, err, recorder := serve(t, "application/json", `{"name":"`+strings.Repeat("a", 128)+`"}`, provider, scene.WithMaxBodyBytes(32))
+		require.ErrorIs(t, err, scene.ErrRequestBodyTooLarge)
+		require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+	t.Run("decoder limit", func(t *testing.T) {
+		limited := scene.WithDecoderProvider(encoders.NewContentTypeDecoderProvider(encoders.DecoderOptions{MaxBytes: 8}))
+		_, err, _ := serve(t, "application/json", `{"name":"foobar"}`, limited)
+		require.ErrorIs(t, err, scene.ErrRequestBodyTooLarge)
+	})
+	t.Run("unsupported media type", func(t *testing.T) {
+		_, err, recorder := serve(t, "text/plain", `hello`, provider)
+		require.ErrorIs(t, err, scene.ErrUnsupportedMediaType)
+		require.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+		var response struct {
+			Metadata struct {
+				StatusCode int `json:"statusCode"`
+			} `json:"metadata"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		require.Equal(t, http.StatusUnsupportedMediaType, response.Metadata.StatusCode)
+	})
+	t.Run("no decoder provider", func(t *testing.T) {
+		_, err, recorder := serve(t, "application/json", `{}`)
+		require.ErrorIs(t, err, scene.ErrUnsupportedMediaType)
+		require.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+	})
+}
@@ -0,0 +1,330 @@
+package encoders
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionWriter is a resettable compressing writer for a single content-coding.
+// gzip.Writer and flate.Writer satisfy this, as do most third party zstd and brotli writers.
+type CompressionWriter interface {
+	io.WriteCloser
+	Flush() error
+	// Reset discards the writer's state and makes it write to w, this allows writers to be pooled.
+	Reset(w io.Writer)
+}
+
+// CompressionOptions controls how an encoder compresses its output.
+type CompressionOptions struct {
+	// MinSize is the smallest body (in bytes) that will be compressed, anything smaller is sent as-is.
+	MinSize int
+	// Disabled turns off compression regardless of what the client accepts.
+	Disabled bool
+}
+
+// DefaultCompressionOptions are used by encoders that are not given explicit options.
+// MinSize is zero to preserve the historical behavior of compressing every response the client accepts.
+var DefaultCompressionOptions = CompressionOptions{}
+
+type compressor struct {
+	encoding  string
+	newWriter func(w io.Writer) CompressionWriter
+	pool      sync.Pool
+}
+
+func (c *compressor) get(w io.Writer) CompressionWriter {
+	if cw, ok := c.pool.Get().(CompressionWriter); ok {
+		cw.Reset(w)
+		return cw
+	}
+	return c.newWriter(w)
+}
+
+func (c *compressor) put(cw CompressionWriter) {
+	// Drop the reference to the response writer so it can be collected
+	cw.Reset(io.Discard)
+	c.pool.Put(cw)
+}
+
+var compressorsLock = &sync.RWMutex{}
+
+// Registration order is the server preference when a client weights encodings equally.
+var compressors []*compressor
+
+func init() {
+	RegisterCompressor("gzip", func(w io.Writer) CompressionWriter {
+		return gzip.NewWriter(w)
+	})
+	RegisterCompressor("deflate", func(w io.Writer) CompressionWriter {
+		// The error is only returned for invalid levels
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+}
+
+// RegisterCompressor adds (or replaces) a content-coding that encoders can negotiate, such as zstd or br.
+// Encodings registered first are preferred when a client weights several encodings equally.
+func RegisterCompressor(encoding string, newWriter func(w io.Writer) CompressionWriter) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	c := &compressor{encoding: encoding, newWriter: newWriter}
+	for k, v := range compressors {
+		if v.encoding == encoding {
+			compressors[k] = c
+			return
+		}
+	}
+	compressors = append(compressors, c)
+}
+
+func getCompressor(encoding string) *compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	for _, v := range compressors {
+		if v.encoding == encoding {
+			return v
+		}
+	}
+	return nil
+}
+
+// NegotiateCompression picks the registered content-coding the client prefers based on its Accept-Encoding header.
+// q-values are honored, so "gzip;q=0" excludes gzip. An empty string means the response should not be compressed.
+func NegotiateCompression(reqHeaders http.Header) string {
+	accepted := map[string]float64{}
+	for _, header := range reqHeaders.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			name, q, ok := parseCoding(part)
+			if !ok {
+				continue
+			}
+			if name == "x-gzip" {
+				name = "gzip"
+			}
+			accepted[name] = q
+		}
+	}
+	if len(accepted) == 0 {
+		return ""
+	}
+	starQ, hasStar := accepted["*"]
+	var best string
+	var bestQ float64
+	compressorsLock.RLock()
+	for _, v := range compressors {
+		q, found := accepted[v.encoding]
+		if !found {
+			if !hasStar {
+				continue
+			}
+			q = starQ
+		}
+		// Strictly greater keeps registration order as the tie-breaker
+		if q > bestQ {
+			best, bestQ = v.encoding, q
+		}
+	}
+	compressorsLock.RUnlock()
+	// Only prefer identity when the client explicitly weights it above every compressed option
+	if identityQ, found := accepted["identity"]; found && identityQ > bestQ {
+		return ""
+	}
+	return best
+}
+
+func parseCoding(part string) (name string, q float64, ok bool) {
+	params := strings.Split(part, ";")
+	name = strings.ToLower(strings.TrimSpace(params[0]))
+	if name == "" {
+		return "", 0, false
+	}
+	q = 1
+	for _, param := range params[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0, false
+		}
+		q = parsed
+	}
+	return name, q, true
+}
+
+// CompressWriter is a http.ResponseWriter that compresses its body with the negotiated content-coding.
+// The status code and headers are held back until enough of the body has been written to decide whether
+// compression is worthwhile, so Close must always be called once the body has been written.
+type CompressWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	// Set unless compression is disabled, the response then depends on Accept-Encoding even when it isn't compressed
+	varies     bool
+	minSize    int
+	statusCode int
+	buf        []byte
+	cw         CompressionWriter
+	committed  bool
+	closed     bool
+}
+
+// NewCompressWriter wraps w with the compression negotiated from the request headers.
+func NewCompressWriter(w http.ResponseWriter, reqHeaders http.Header, options CompressionOptions) *CompressWriter {
+	cw := &CompressWriter{
+		ResponseWriter: w,
+		minSize:        options.MinSize,
+	}
+	if !options.Disabled {
+		cw.varies = true
+		if encoding := NegotiateCompression(reqHeaders); encoding != "" {
+			cw.compressor = getCompressor(encoding)
+		}
+	}
+	return cw
+}
+
+// Encoding returns the content-coding that will be used if the body reaches the minimum size.
+func (c *CompressWriter) Encoding() string {
+	if c.compressor == nil {
+		return ""
+	}
+	return c.compressor.encoding
+}
+
+// WriteHeader records the status code, it is sent once the compression decision has been made.
+func (c *CompressWriter) WriteHeader(statusCode int) {
+	if c.committed || c.statusCode != 0 {
+		return
+	}
+	c.statusCode = statusCode
+}
+
+func (c *CompressWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if c.committed {
+		if c.cw != nil {
+			return c.cw.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	if !c.compressible() {
+		// Nothing to decide, the body is never compressed
+		c.commit(false)
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		if err := c.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush commits the response and pushes any buffered data to the client.
+// Flushing before the minimum size is reached still compresses, as flushed responses are assumed to be streams.
+func (c *CompressWriter) Flush() {
+	if c.closed {
+		return
+	}
+	if !c.committed {
+		if err := c.commit(c.compressible()); err != nil {
+			return
+		}
+	}
+	if c.cw != nil {
+		if err := c.cw.Flush(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close commits the response if needed and finishes the compressed stream.
+// An empty body is never compressed, a gzip footer on its own would only make the response larger.
+func (c *CompressWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	var err error
+	if !c.committed {
+		err = c.commit(c.compressible() && len(c.buf) > 0 && len(c.buf) >= c.minSize)
+	}
+	c.closed = true
+	if c.cw != nil {
+		if closeErr := c.cw.Close(); err == nil {
+			err = closeErr
+		}
+		c.compressor.put(c.cw)
+		c.cw = nil
+	}
+	return err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (c *CompressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// compressible reports whether a compressor was negotiated and the status code allows a body to compress.
+func (c *CompressWriter) compressible() bool {
+	if c.compressor == nil {
+		return false
+	}
+	switch {
+	case c.statusCode >= 100 && c.statusCode < 200, c.statusCode == http.StatusNoContent, c.statusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+func (c *CompressWriter) commit(compress bool) error {
+	c.committed = true
+	header := c.ResponseWriter.Header()
+	// Caches need to key every compressible response on Accept-Encoding, including the uncompressed ones
+	if c.varies && !hasToken(header.Values("Vary"), "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		header.Set("Content-Encoding", c.compressor.encoding)
+		header.Del("Content-Length")
+		c.cw = c.compressor.get(c.ResponseWriter)
+	}
+	if c.statusCode != 0 {
+		c.ResponseWriter.WriteHeader(c.statusCode)
+	}
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.cw != nil {
+		_, err = c.cw.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package encoders
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/weisbartb/scene"
+)
+
+// DecoderOptions controls how request bodies are decoded.
+type DecoderOptions struct {
+	// MaxBytes limits the size of the body, 0 leaves it unbounded (the middleware may still enforce a limit).
+	MaxBytes int64
+	// Strict rejects fields in the body that do not exist on the target.
+	// This applies to JSON and form bodies, encoding/xml has no notion of unknown fields.
+	Strict bool
+	// MaxMemory is the amount of a multipart form that is held in memory, defaults to 32MB.
+	MaxMemory int64
+}
+
+// NewContentTypeDecoderProvider creates a decoder provider that selects the decoder from the request's Content-Type.
+// JSON (including +json types), XML (including +xml types), url-encoded and multipart forms are supported.
+// Any other content type produces a decoder that fails with a 415.
+func NewContentTypeDecoderProvider(options DecoderOptions) scene.DecoderProvider {
+	return func(ctx scene.Context, request *http.Request) scene.RequestDecoder {
+		mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+		if err != nil {
+			return unsupportedDecoder{contentType: request.Header.Get("Content-Type")}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			return NewJSONDecoder(request, options)
+		case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+			return NewXMLDecoder(request, options)
+		case mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data":
+			return NewFormDecoder(request, options)
+		default:
+			return unsupportedDecoder{contentType: mediaType}
+		}
+	}
+}
+
+type unsupportedDecoder struct {
+	contentType string
+}
+
+func (u unsupportedDecoder) Decode(obj any) error {
+	return scene.NewDecodeError(scene.ErrUnsupportedMediaType, fmt.Errorf("content type %q can not be decoded", u.contentType))
+}
+
+func limitBody(request *http.Request, options DecoderOptions) io.Reader {
+	if request.Body == nil {
+		return http.NoBody
+	}
+	if options.MaxBytes > 0 {
+		request.Body = http.MaxBytesReader(nil, request.Body, options.MaxBytes)
+	}
+	return request.Body
+}
+
+// wrapDecodeErr classifies errors from the standard library decoders.
+func wrapDecodeErr(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return scene.NewDecodeError(scene.ErrRequestBodyTooLarge, err)
+	}
+	return scene.NewDecodeError(scene.ErrMalformedRequestBody, err)
+}
+
+type jsonDecoder struct {
+	request *http.Request
+	options DecoderOptions
+}
+
+// NewJSONDecoder creates a decoder that reads a single JSON value from the request body.
+func NewJSONDecoder(request *http.Request, options DecoderOptions) scene.RequestDecoder {
+	return &jsonDecoder{request: request, options: options}
+}
+
+func (j *jsonDecoder) Decode(obj any) error {
+	decoder := json.NewDecoder(limitBody(j.request, j.options))
+	if j.options.Strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(obj); err != nil {
+		if err == io.EOF {
+			err = errors.New("request body is empty")
+		}
+		return wrapDecodeErr(err)
+	}
+	// Make sure there isn't a second document or trailing garbage
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("request body must contain a single JSON value")
+		}
+		return wrapDecodeErr(err)
+	}
+	return nil
+}
+
+type xmlDecoder struct {
+	request *http.Request
+	options DecoderOptions
+}
+
+// NewXMLDecoder creates a decoder that reads an XML document from the request body.
+func NewXMLDecoder(request *http.Request, options DecoderOptions) scene.RequestDecoder {
+	return &xmlDecoder{request: request, options: options}
+}
+
+func (x *xmlDecoder) Decode(obj any) error {
+	if err := xml.NewDecoder(limitBody(x.request, x.options)).Decode(obj); err != nil {
+		if err == io.EOF {
+			err = errors.New("request body is empty")
+		}
+		return wrapDecodeErr(err)
+	}
+	return nil
+}
+
+type formDecoder struct {
+	request *http.Request
+	options DecoderOptions
+}
+
+// NewFormDecoder creates a decoder for url-encoded and multipart forms.
+// Targets can be *url.Values, *map[string][]string or a pointer to a struct using `form:"name"` tags.
+// Struct fields can be strings, bools, ints, uints, floats or slices of those.
+func NewFormDecoder(request *http.Request, options DecoderOptions) scene.RequestDecoder {
+	return &formDecoder{request: request, options: options}
+}
+
+func (f *formDecoder) Decode(obj any) error {
+	if f.request.Body != nil && f.options.MaxBytes > 0 {
+		f.request.Body = http.MaxBytesReader(nil, f.request.Body, f.options.MaxBytes)
+	}
+	var err error
+	if strings.HasPrefix(f.request.Header.Get("Content-Type"), "multipart/") {
+		maxMemory := f.options.MaxMemory
+		if maxMemory == 0 {
+			maxMemory = 32 << 20
+		}
+		err = f.request.ParseMultipartForm(maxMemory)
+	} else {
+		err = f.request.ParseForm()
+	}
+	if err != nil {
+		return wrapDecodeErr(err)
+	}
+	values := f.request.PostForm
+	if f.request.MultipartForm != nil {
+		values = f.request.MultipartForm.Value
+	}
+	switch target := obj.(type) {
+	case *url.Values:
+		*target = values
+		return nil
+	case *map[string][]string:
+		*target = values
+		return nil
+	}
+	if err := decodeForm(values, obj, f.options.Strict); err != nil {
+		return scene.NewDecodeError(scene.ErrMalformedRequestBody, err)
+	}
+	return nil
+}
+
+func decodeForm(values url.Values, obj any, strict bool) error {
+	target := reflect.ValueOf(obj)
+	if target.Kind() != reflect.Pointer || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return errors.New("form targets must be a non-nil pointer to a struct")
+	}
+	target = target.Elem()
+	targetType := target.Type()
+	known := make(map[string]struct{}, targetType.NumField())
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, found := field.Tag.Lookup("form"); found {
+			tag, _, _ = strings.Cut(tag, ",")
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		known[name] = struct{}{}
+		formValues, found := values[name]
+		if !found || len(formValues) == 0 {
+			continue
+		}
+		if err := setFormField(target.Field(i), formValues); err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+	}
+	if strict {
+		for k := range values {
+			if _, found := known[k]; !found {
+				return fmt.Errorf("unknown field %q", k)
+			}
+		}
+	}
+	return nil
+}
+
+func setFormField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for k, v := range values {
+			if err := setFormValue(slice.Index(k), v); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setFormValue(field, values[0])
+}
+
+func setFormValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+	return nil
+}
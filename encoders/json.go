@@ -1,26 +1,59 @@
 package encoders
 
 import (
-	"compress/gzip"
 	"encoding/json"
 	"github.com/weisbartb/scene"
-	"io"
+	"mime"
 	"net/http"
 	"strings"
 )
 
+// StreamFormat controls how a JSON encoder lays out streamed items.
+type StreamFormat int
+
+const (
+	// StreamFormatAuto uses NDJSON when the client accepts application/x-ndjson, otherwise a JSON array.
+	StreamFormatAuto StreamFormat = iota
+	// StreamFormatNDJSON writes one item per line.
+	// If the stream fails after it started, the final line is the response wrapper carrying the error.
+	StreamFormatNDJSON
+	// StreamFormatArray writes every item as an element of a single JSON array.
+	// If the stream fails after it started, the array is closed and the error is only reported in the trailer.
+	StreamFormatArray
+)
+
+// JSONOptions configures a JSON encoder.
+type JSONOptions struct {
+	Compression  CompressionOptions
+	StreamFormat StreamFormat
+}
+
 type jsonEncoder struct {
 	w            http.ResponseWriter
 	baseResponse ResponseWrapper
-	gzip         bool
+	reqHeaders   http.Header
+	options      JSONOptions
+	encoded      bool
+	errored      bool
 }
 
 func NewJSONEncoder(reqHeaders http.Header, generator ResponseGenerator) scene.ResponseEncoder {
+	return NewJSONEncoderWithOptions(reqHeaders, generator, JSONOptions{Compression: DefaultCompressionOptions})
+}
+
+// NewJSONEncoderWithCompression creates a JSON encoder that negotiates compression using the provided options.
+func NewJSONEncoderWithCompression(reqHeaders http.Header, generator ResponseGenerator, options CompressionOptions) scene.ResponseEncoder {
+	return NewJSONEncoderWithOptions(reqHeaders, generator, JSONOptions{Compression: options})
+}
+
+// NewJSONEncoderWithOptions creates a JSON encoder, the returned encoder also implements scene.StreamEncoder.
+func NewJSONEncoderWithOptions(reqHeaders http.Header, generator ResponseGenerator, options JSONOptions) scene.ResponseEncoder {
 	wrapper := generator.New()
 	return &jsonEncoder{
 		w:            nil,
 		baseResponse: wrapper,
-		gzip:         strings.Contains(reqHeaders.Get("Accept-Encoding"), "gzip"),
+		reqHeaders:   reqHeaders,
+		options:      options,
 	}
 }
 
@@ -33,18 +66,104 @@ func (j *jsonEncoder) SetWriter(ctx scene.Context, w http.ResponseWriter) {
 }
 
 func (j *jsonEncoder) AddError(err error, statusCode int) {
+	j.errored = true
 	j.baseResponse.AddError(err, statusCode)
 }
 
+// HasPendingErrors reports whether errors were added that haven't been encoded yet.
+func (j *jsonEncoder) HasPendingErrors() bool {
+	return j.errored && !j.encoded
+}
+
 func (j *jsonEncoder) Encode(obj any) error {
-	var w io.Writer = j.w
-	if j.gzip {
-		j.w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(j.w)
-		w = gz
-		defer gz.Close()
+	if j.encoded {
+		return scene.ErrAlreadyEncoded
 	}
+	j.encoded = true
+	defer scene.MarkEncoded(j.w)
+	w := NewCompressWriter(j.w, j.reqHeaders, j.options.Compression)
 	j.w.Header().Set("Content-Type", "application/json")
-	j.w.WriteHeader(j.baseResponse.GetStatusCode())
-	return json.NewEncoder(w).Encode(j.baseResponse.Wrap(j.w, obj))
+	w.WriteHeader(j.baseResponse.GetStatusCode())
+	if err := json.NewEncoder(w).Encode(j.baseResponse.Wrap(j.w, obj)); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// EncodeStream writes items from source as NDJSON or a JSON array, flushing after each item.
+func (j *jsonEncoder) EncodeStream(ctx scene.Context, source scene.StreamSource) error {
+	if j.encoded {
+		return scene.ErrAlreadyEncoded
+	}
+	item, ok, err := scene.NextStreamItem(ctx, source)
+	if err != nil {
+		// Nothing has been committed yet so the error can be reported with a proper status code
+		j.AddError(err, scene.StreamErrorStatus(err))
+		if encodeErr := j.Encode(nil); encodeErr != nil {
+			return encodeErr
+		}
+		return err
+	}
+	j.encoded = true
+	defer scene.MarkEncoded(j.w)
+	ndjson := j.ndjson()
+	header := j.w.Header()
+	if ndjson {
+		header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		header.Set("Content-Type", "application/json")
+	}
+	header.Add("Trailer", scene.StreamErrorTrailer)
+	w := NewCompressWriter(j.w, j.reqHeaders, j.options.Compression)
+	w.WriteHeader(j.baseResponse.GetStatusCode())
+	encoder := json.NewEncoder(w)
+	if !ndjson {
+		_, err = w.Write([]byte("["))
+	}
+	for written := 0; ok && err == nil; written++ {
+		if !ndjson && written > 0 {
+			if _, err = w.Write([]byte(",")); err != nil {
+				break
+			}
+		}
+		if err = encoder.Encode(item); err != nil {
+			break
+		}
+		w.Flush()
+		item, ok, err = scene.NextStreamItem(ctx, source)
+	}
+	if !ndjson {
+		_, _ = w.Write([]byte("]\n"))
+	}
+	if err != nil {
+		// Trailers are set by writing the declared header after the body has started
+		header.Set(scene.StreamErrorTrailer, err.Error())
+		if ndjson {
+			j.AddError(err, scene.StreamErrorStatus(err))
+			_ = encoder.Encode(j.baseResponse.Wrap(j.w, nil))
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (j *jsonEncoder) ndjson() bool {
+	switch j.options.StreamFormat {
+	case StreamFormatNDJSON:
+		return true
+	case StreamFormatArray:
+		return false
+	}
+	for _, accept := range j.reqHeaders.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(part)
+			if err == nil && (mediaType == "application/x-ndjson" || mediaType == "application/jsonl") {
+				return true
+			}
+		}
+	}
+	return false
 }
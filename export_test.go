@@ -0,0 +1,7 @@
+package scene
+
+// SetCopyDefaults makes factory copy its defaults into every new context, benchmarks use it to compare with
+// the design that preceded the shared snapshots.
+func SetCopyDefaults(factory *Factory, copyDefaults bool) {
+	factory.copyDefaults = copyDefaults
+}
@@ -5,8 +5,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"maps"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,101 +20,160 @@ type Config struct {
 	FactoryIdentifier string // Makes it easier to track down stuck contexts
 	MaxTTL            time.Duration
 	LogOutput         zerolog.Logger
-	DebugMode         bool
+	// DebugMode logs the keys of a scene at debug level when it times out, values are left out since they may be
+	// sensitive.
+	DebugMode bool
+	// ForceCompleteOnShutdown completes any contexts still open once the Shutdown deadline elapses with
+	// ErrShutdownInProgress, running their Defer callbacks before providers are unmounted.
+	ForceCompleteOnShutdown bool
+	// PoolContexts reuses completed contexts for new scenes to cut allocations in hot paths.
+	// Scenes are handed out as handles that act like a completed scene once the context is reused,
+	// so values stored on a scene can't be read after it completes.
+	PoolContexts bool
+	// InheritParentValues makes scenes created with Spawn look up keys they don't have on the live parent scene,
+	// so values stored on the parent after the spawn (or not copied by OnSpawnedContext) are visible to the child.
+	// Values set on the parent take priority over the child's defaults.
+	InheritParentValues bool
+	// InheritKeys limits InheritParentValues to these keys, every key is inherited if it is empty.
+	InheritKeys []any
 }
 
 type Factory struct {
-	closed               atomic.Bool
-	defaultsLock         *sync.RWMutex
-	requestTTL           time.Duration
-	injectors            []Provider
-	defaultContextValues map[any]any
-	defaultContextCt     int
-	openContexts         int32
-	openContextWg        *sync.WaitGroup
-	factoryLogger        zerolog.Logger
-	factoryIdentifier    string
-	config               Config
-	done                 chan struct{}
+	closed       atomic.Bool
+	defaultsLock *sync.RWMutex
+	requestTTL   time.Duration
+	// Copy-on-write list of mounted providers, only replaced while defaultsLock is held
+	injectors atomic.Pointer[[]*mountedProvider]
+	// Swapped out providers waiting on their last context to complete
+	retired []*mountedProvider
+	// Retired providers unmounting in the background after their last context completed
+	retiringLock *sync.Mutex
+	retiring     []*retirement
+	// Immutable snapshot of the defaults, replaced as a whole while defaultsLock is held.
+	// Contexts keep a reference to the snapshot they were created with instead of copying it.
+	defaults          atomic.Pointer[map[any]any]
+	openContexts      int32
+	openContextWg     *sync.WaitGroup
+	factoryLogger     zerolog.Logger
+	factoryIdentifier string
+	config            Config
+	done              chan struct{}
+	// Every context that has not completed, used to force-complete stragglers during shutdown
+	openLock *sync.Mutex
+	open     map[*context]struct{}
+	// Lifecycle state and the hooks waiting on each state
+	state         atomic.Int32
+	lifecycleLock *sync.Mutex
+	hooks         map[LifecycleState][]LifecycleHook
+	// Set for factories created with NewChild
+	parent       *Factory
+	childrenLock *sync.Mutex
+	children     []*Factory
+	// Completed contexts waiting to be reused when Config.PoolContexts is set
+	pool *sync.Pool
+	// Allow-list built from Config.InheritKeys
+	inheritKeys map[any]struct{}
+	// Copies the defaults into every context the way scenes did before the snapshots were shared,
+	// only set by benchmarks to compare against that design
+	copyDefaults bool
 }
 
 func (factory *Factory) StoreDefault(key, value any) {
 	factory.defaultsLock.Lock()
-	if _, found := factory.defaultContextValues[key]; !found {
-		factory.defaultContextCt++
-	}
-	factory.defaultContextValues[key] = value
+	factory.storeDefault(key, value)
 	factory.defaultsLock.Unlock()
 }
 
+// storeDefault replaces the defaults snapshot with a copy holding key, defaultsLock must be held.
+func (factory *Factory) storeDefault(key, value any) {
+	current := factory.defaultValues()
+	defaults := make(map[any]any, len(current)+1)
+	for k, v := range current {
+		defaults[k] = v
+	}
+	defaults[key] = value
+	factory.defaults.Store(&defaults)
+}
+
+// defaultValues gets the current defaults snapshot, the map must not be modified.
+func (factory *Factory) defaultValues() map[any]any {
+	return *factory.defaults.Load()
+}
+
 // GetDefault pulls the default injector for new contexts for a given key.
+// Child factories fall back to their parent's defaults.
 func (factory *Factory) GetDefault(key any) any {
-	factory.defaultsLock.RLock()
-	defer factory.defaultsLock.RUnlock()
-	return factory.defaultContextValues[key]
+	val, found := factory.defaultValues()[key]
+	if !found && factory.parent != nil {
+		return factory.parent.GetDefault(key)
+	}
+	return val
 }
 
 // NewSceneFactory creates a new context factory off a given configuration.
 // Factories should be created with all injectors allocated at the time they are created.
-// Dynamic addition of injectors is not supported
+// Providers can be added with Register, refreshed with Reload or replaced with Swap afterward.
 func NewSceneFactory(config Config, injectors ...Provider) (*Factory, error) {
+	return newFactory(config, nil, injectors), nil
+}
+
+func newFactory(config Config, parent *Factory, injectors []Provider) *Factory {
 	factory := &Factory{
-		defaultsLock:         &sync.RWMutex{},
-		requestTTL:           config.MaxTTL,
-		factoryLogger:        config.LogOutput,
-		factoryIdentifier:    config.FactoryIdentifier,
-		defaultContextValues: make(map[any]any),
-		openContextWg:        &sync.WaitGroup{},
-		injectors:            injectors,
-		done:                 make(chan struct{}),
-		config:               config,
+		defaultsLock:      &sync.RWMutex{},
+		requestTTL:        config.MaxTTL,
+		factoryLogger:     config.LogOutput,
+		factoryIdentifier: config.FactoryIdentifier,
+		openContextWg:     &sync.WaitGroup{},
+		done:              make(chan struct{}),
+		config:            config,
+		openLock:          &sync.Mutex{},
+		open:              make(map[*context]struct{}),
+		lifecycleLock:     &sync.Mutex{},
+		hooks:             make(map[LifecycleState][]LifecycleHook),
+		childrenLock:      &sync.Mutex{},
+		retiringLock:      &sync.Mutex{},
+		parent:            parent,
+		pool:              &sync.Pool{},
 	}
-	// Bind all mounts
-	for _, v := range injectors {
-		if v != nil {
-			v.OnFactoryMount(factory)
+	defaults := make(map[any]any)
+	factory.defaults.Store(&defaults)
+	if len(config.InheritKeys) > 0 {
+		factory.inheritKeys = make(map[any]struct{}, len(config.InheritKeys))
+		for _, key := range config.InheritKeys {
+			factory.inheritKeys[key] = struct{}{}
 		}
 	}
-	return factory, nil
+	mounted := mountProviders(factory, injectors)
+	factory.injectors.Store(&mounted)
+	// Bind all mounts
+	for _, v := range mounted {
+		v.OnFactoryMount(factory)
+	}
+	return factory
 }
 
 // Shutdown ensures that background tasks are completed before the factory shut down, returns true if a clean shutdown
 // occurred
 // A deadline that is at least as long as the average request context is recommended
+// See ShutdownWithReport for finer control over each phase of the shutdown.
 func (factory *Factory) Shutdown(deadline time.Duration) bool {
+	return factory.ShutdownWithReport(ShutdownConfig{
+		DrainTimeout:  deadline,
+		ForceComplete: factory.config.ForceCompleteOnShutdown,
+	}).Drained
+}
+
+// stopAccepting rejects new contexts and notifies listeners of Done, returns false if shutdown already started.
+func (factory *Factory) stopAccepting() bool {
 	// Set the shutdown bit
 	if !factory.closed.CompareAndSwap(false, true) {
 		return false
 	}
 	close(factory.done)
-	c := make(chan struct{})
-	go func() {
-		factory.openContextWg.Wait()
-		close(c)
-	}()
-	defer func() {
-		for k := len(factory.injectors); k >= 0; k-- {
-			func() {
-				factory.defaultsLock.RLock()
-				defer factory.defaultsLock.RUnlock()
-				defer func() {
-					// Handle any panics that are recoverable and bubbled up through.
-					if r := recover(); r != nil {
-						factory.factoryLogger.Error().Interface("err", r).Send()
-					}
-				}()
-				if err := factory.injectors[k].OnFactoryUnmount(factory); err != nil {
-					factory.factoryLogger.Error().Err(err).Send()
-				}
-			}()
-		}
-	}()
-	select {
-	case <-c:
-		return true
-	case <-time.After(deadline):
-		return false
-	}
+	// Long-lived scenes get the head start of their grace period before any hook runs
+	factory.notifyShutdown()
+	factory.setState(StateDraining)
+	return true
 }
 
 func (factory *Factory) Done() <-chan struct{} {
@@ -121,11 +182,26 @@ func (factory *Factory) Done() <-chan struct{} {
 
 // Wrap a context with a core context
 func (factory *Factory) Wrap(ctx ogContext.Context) (Context, error) {
+	return factory.WrapWithTTL(ctx, factory.config.MaxTTL)
+}
+
+// WrapWithTTL wraps a context with a core context that completes after ttl instead of the factory's MaxTTL.
+// NoTTL creates a context without a deadline.
+func (factory *Factory) WrapWithTTL(ctx ogContext.Context, ttl time.Duration) (Context, error) {
 	if factory.closed.Load() {
 		return nil, ErrShutdownInProgress
 	}
-	newCtx := factory.newCtx(ctx, factory.requestTTL)
-	return newCtx, nil
+	newCtx := factory.newCtx(ctx, ttl)
+	return newCtx.start(), nil
+}
+
+// inherits checks the InheritKeys allow-list.
+func (factory *Factory) inherits(key any) bool {
+	if factory.inheritKeys == nil {
+		return true
+	}
+	_, found := factory.inheritKeys[key]
+	return found
 }
 
 // OpenContexts gets the count of all the open contexts
@@ -138,46 +214,105 @@ func (factory *Factory) NewCtx() (Context, error) {
 	if factory.closed.Load() {
 		return nil, ErrShutdownInProgress
 	}
-	return factory.newCtx(ogContext.Background(), factory.requestTTL), nil
+	return factory.newCtx(ogContext.Background(), factory.requestTTL).start(), nil
 }
 
-func (factory *Factory) newCtx(baseCtx ogContext.Context, deadline time.Duration) Context {
+func (factory *Factory) newCtx(baseCtx ogContext.Context, deadline time.Duration) *context {
 	atomic.AddInt32(&factory.openContexts, 1)
 	factory.openContextWg.Add(1)
 	requestID := uuid.New().String()
-	ctx := &context{
-		Context:       baseCtx,
-		factory:       factory,
-		complete:      make(chan struct{}),
-		contextValues: make(map[any]any, factory.defaultContextCt+10), // Pre-size the context
-		id:            requestID,
-		mu:            &sync.RWMutex{},
+	var ctx *context
+	if factory.config.PoolContexts {
+		ctx = factory.acquire(baseCtx, requestID)
+	} else {
+		ctx = &context{
+			Context:       baseCtx,
+			factory:       factory,
+			complete:      make(chan struct{}),
+			contextValues: make(map[any]any, 8), // Only holds values stored on this context, defaults are shared
+			id:            requestID,
+			mu:            &sync.RWMutex{},
+		}
+		ctx.self = ctx
 	}
 	ctx.contextValues[RequestIDKey{}] = ctx.id
-	// Increase the open contexts (used to make sure we don't shut down with an active context)
-	factory.defaultsLock.RLock()
-	defer factory.defaultsLock.RUnlock()
-	// Copy all defaults into the context
-	for k, v := range factory.defaultContextValues {
-		ctx.contextValues[k] = v
+	factory.openLock.Lock()
+	factory.open[ctx] = struct{}{}
+	factory.openLock.Unlock()
+	if factory.parent == nil {
+		factory.defaultsLock.RLock()
+		defer factory.defaultsLock.RUnlock()
+		// Reference the defaults snapshot and pin the providers this context was created with,
+		// so swapped out providers stay mounted until it completes
+		ctx.defaults = []map[any]any{factory.defaultValues()}
+		ctx.providers = factory.providers()
+	} else {
+		// Child factories inherit the defaults and providers of every ancestor, oldest first so a child can override
+		lineage := factory.lineage()
+		for _, ancestor := range lineage {
+			ancestor.defaultsLock.RLock()
+			defer ancestor.defaultsLock.RUnlock()
+		}
+		ctx.defaults = make([]map[any]any, len(lineage))
+		for k, ancestor := range lineage {
+			ctx.defaults[k] = ancestor.defaultValues()
+			ctx.providers = append(ctx.providers, ancestor.providers()...)
+		}
 	}
-	// Run hooks for every module
-	for _, v := range factory.injectors {
-		if v != nil {
-			v.OnNewContext(ctx)
+	if factory.copyDefaults {
+		for k, v := range ctx.defaults {
+			ctx.defaults[k] = maps.Clone(v)
 		}
 	}
+	for _, v := range ctx.providers {
+		v.refs.Add(1)
+	}
+	// Run hooks for every module
+	for _, v := range ctx.providers {
+		v.OnNewContext(ctx.self)
+	}
 	ctx.startedAt = time.Now()
 	// Get what created this context for debug purposes
-	_, file, line, _ := runtime.Caller(2)
-	ctx.startedBy = file + ":" + strconv.Itoa(line)
+	ctx.startedBy = startedBy()
 	ctx.deadline = deadline
 	// Store the initial base context that was used to create this.
 	// If no values are found in this context, it will resolve this context chain to try to find the value.
-	ctx.contextValues[ContextRef{}] = ctx
+	ctx.contextValues[ContextRef{}] = ctx.self
 	if deadline > 0 {
 		ctx.completeBy = time.Now().Add(deadline).UnixNano()
-		go ctx.refreshDeadline()
 	}
 	return ctx
 }
+
+// startedBy gets the file and line that created a context, newCtx's callers are expected to call it directly.
+// Wrap goes through WrapWithTTL and Spawn through spawn, so their frames are skipped to report what called them instead.
+func startedBy() string {
+	pcs := make([]uintptr, 2)
+	// Skip runtime.Callers, startedBy, newCtx and the method that called it
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(4, pcs)])
+	frame, more := frames.Next()
+	for more && passesThrough(frame.Function) {
+		frame, more = frames.Next()
+	}
+	return frame.File + ":" + strconv.Itoa(frame.Line)
+}
+
+// passesThrough reports whether function only forwards to the method that calls newCtx.
+func passesThrough(function string) bool {
+	for _, suffix := range []string{".(*Factory).Wrap", ".(*context).Spawn", ".(*pooledContext).Spawn"} {
+		if strings.HasSuffix(function, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// start begins the deadline of a new context and gets the Context to hand out.
+// This is the last access to a new context, once the deadline runs a pooled context may be completed and reused.
+func (c *context) start() Context {
+	self := c.self
+	if c.deadline > 0 {
+		go c.refreshDeadline(c.generation.Load())
+	}
+	return self
+}
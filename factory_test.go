@@ -1,6 +1,8 @@
 package scene_test
 
 import (
+	"context"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 	"github.com/weisbartb/scene"
@@ -68,3 +70,146 @@ func TestFactory_OpenContexts(t *testing.T) {
 		require.Equal(t, 10-(k+1), factory.OpenContexts())
 	}
 }
+
+func TestFactory_WrapWithTTL(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.WrapWithTTL(context.Background(), time.Millisecond*20)
+	require.NoError(t, err)
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	require.LessOrEqual(t, time.Until(deadline), time.Millisecond*20)
+	<-ctx.Done()
+	require.ErrorIs(t, ctx.Err(), scene.ErrTimeout)
+
+	ctx, err = factory.WrapWithTTL(context.Background(), scene.NoTTL)
+	require.NoError(t, err)
+	_, ok = ctx.Deadline()
+	require.False(t, ok)
+	ctx.Complete()
+}
+
+func TestFactory_WrapStartedBy(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Millisecond * 10,
+		LogOutput:         logger,
+		DebugMode:         true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	// Wrap uses the factory's MaxTTL and reports its caller, not WrapWithTTL's
+	ctx, err := factory.Wrap(context.Background())
+	require.NoError(t, err)
+	<-ctx.Done()
+	require.ErrorIs(t, ctx.Err(), scene.ErrTimeout)
+	require.Contains(t, buf.String(), "factory_test.go:")
+}
+
+func TestContext_SpawnStartedBy(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pooled-%v", pooled), func(t *testing.T) {
+			buf := tsbuffer.New()
+			logger := zerolog.New(buf)
+			factory, _ := scene.NewSceneFactory(scene.Config{
+				FactoryIdentifier: "Test",
+				MaxTTL:            scene.NoTTL,
+				LogOutput:         logger,
+				DebugMode:         true,
+				PoolContexts:      pooled,
+			})
+			t.Cleanup(func() {
+				require.True(t, factory.Shutdown(time.Second))
+			})
+			parent, err := factory.NewCtx()
+			require.NoError(t, err)
+			defer parent.Complete()
+			// Spawn reports its caller, not the context method it goes through
+			ctx, err := parent.Spawn(time.Now().Add(time.Millisecond * 10))
+			require.NoError(t, err)
+			<-ctx.Done()
+			require.Contains(t, buf.String(), "factory_test.go:")
+			require.NotContains(t, buf.String(), "context.go:")
+			require.NotContains(t, buf.String(), "pool.go:")
+		})
+	}
+}
+
+func TestFactory_DefaultsSnapshot(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault("test", "val")
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	// Open contexts keep the snapshot they were created with
+	factory.StoreDefault("test", "val2")
+	factory.StoreDefault("other", "val")
+	require.Equal(t, "val", ctx.Value("test"))
+	require.Nil(t, ctx.Value("other"))
+	// Values stored on the context overlay the defaults without changing them
+	ctx.Store("test", "overlay")
+	require.Equal(t, "overlay", ctx.Value("test"))
+	ctx.Store("test", nil)
+	require.Nil(t, ctx.Value("test"))
+	require.Equal(t, "val2", factory.GetDefault("test"))
+	ctx2, err := factory.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, "val2", ctx2.Value("test"))
+	ctx2.Complete()
+}
+
+func newBenchmarkFactory(b *testing.B, defaults int) *scene.Factory {
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Bench",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         zerolog.Nop(),
+	}, scene.BaseProvider{})
+	for i := 0; i < defaults; i++ {
+		factory.StoreDefault(i, i)
+	}
+	b.Cleanup(func() {
+		factory.Shutdown(time.Second)
+	})
+	return factory
+}
+
+// BenchmarkFactory_NewCtx measures creating a context as the number of defaults grows.
+// Contexts reference the defaults snapshot, so allocations should stay flat, the copy runs copy the defaults into
+// every context the way scenes used to for comparison.
+func BenchmarkFactory_NewCtx(b *testing.B) {
+	for _, mode := range []string{"shared", "copy"} {
+		for _, defaults := range []int{0, 10, 50} {
+			b.Run(fmt.Sprintf("%s/defaults-%d", mode, defaults), func(b *testing.B) {
+				factory := newBenchmarkFactory(b, defaults)
+				scene.SetCopyDefaults(factory, mode == "copy")
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					ctx, _ := factory.NewCtx()
+					_ = ctx.Value(0)
+					ctx.Complete()
+				}
+			})
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/weisbartb/scene
 
-go 1.20
+go 1.22
 
 require (
 	github.com/google/uuid v1.3.0
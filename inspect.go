@@ -0,0 +1,290 @@
+package scene
+
+import (
+	ogContext "context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// mergedValues merges the factory defaults, the values inherited from the scene this was spawned from and the values
+// stored on the context, in the order Lookup checks them. nil is returned once the context was reused.
+func (c *context) mergedValues(generation uint64) map[any]any {
+	c.mu.RLock()
+	if !c.current(generation) {
+		c.mu.RUnlock()
+		return nil
+	}
+	merged := make(map[any]any, len(c.contextValues))
+	for _, defaults := range c.defaults {
+		for key, value := range defaults {
+			merged[key] = value
+		}
+	}
+	stored := make(map[any]any, len(c.contextValues))
+	for key, value := range c.contextValues {
+		stored[key] = value
+	}
+	parent := c.inheritFrom
+	c.mu.RUnlock()
+	if parent != nil {
+		for key, value := range inheritedValues(parent) {
+			if c.factory.inherits(key) {
+				merged[key] = value
+			}
+		}
+	}
+	for key, value := range stored {
+		if _, isDeleted := value.(deleted); isDeleted {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// inheritedValues collects the values inheritedValue can find on a parent scene and the scenes it inherits from.
+func inheritedValues(parent Context) map[any]any {
+	p, generation, ok := asScene(parent)
+	if !ok {
+		return nil
+	}
+	p.mu.RLock()
+	if !p.current(generation) {
+		p.mu.RUnlock()
+		return nil
+	}
+	stored := make(map[any]any, len(p.contextValues))
+	for key, value := range p.contextValues {
+		if _, built := p.lazy[key]; !built {
+			stored[key] = value
+		}
+	}
+	grandparent := p.inheritFrom
+	p.mu.RUnlock()
+	var values map[any]any
+	if grandparent != nil {
+		values = inheritedValues(grandparent)
+	}
+	if values == nil {
+		values = make(map[any]any, len(stored))
+	}
+	for key, value := range stored {
+		if _, isDeleted := value.(deleted); isDeleted {
+			delete(values, key)
+			continue
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// Range calls fn for every key the scene resolves until fn returns false: stored values, values inherited from the
+// scene it was spawned from (see Config.InheritParentValues) and factory defaults. Values of the base context a scene
+// wraps can't be listed, so Lookup may find keys Range doesn't.
+// fn runs on a copy of the values so it can use the scene. Lazy values are passed as their LazyValue, they aren't built.
+func (c *context) Range(fn func(key, value any) bool) {
+	rangeValues(c.mergedValues(c.generation.Load()), fn)
+}
+
+// Keys lists every key the scene resolves, in no particular order.
+func (c *context) Keys() []any {
+	return keysOf(c.mergedValues(c.generation.Load()))
+}
+
+func (p *pooledContext) Range(fn func(key, value any) bool) {
+	rangeValues(p.c.mergedValues(p.generation), fn)
+}
+
+func (p *pooledContext) Keys() []any {
+	if values := p.c.mergedValues(p.generation); values != nil {
+		return keysOf(values)
+	}
+	return nil
+}
+
+// mergedValues merges the view's overrides into the values of the Scene (or view) below it.
+func (v *valueView) mergedValues() map[any]any {
+	merged := make(map[any]any)
+	v.Context.Range(func(key, value any) bool {
+		merged[key] = value
+		return true
+	})
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for key, value := range v.values {
+		if _, isDeleted := value.(deleted); isDeleted {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+func (v *valueView) Range(fn func(key, value any) bool) {
+	rangeValues(v.mergedValues(), fn)
+}
+
+func (v *valueView) Keys() []any {
+	return keysOf(v.mergedValues())
+}
+
+func rangeValues(values map[any]any, fn func(key, value any) bool) {
+	for key, value := range values {
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+func keysOf(values map[any]any) []any {
+	keys := make([]any, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// formatKey names a key by its type, keys with a value (such as string keys) also include the value.
+func formatKey(key any) string {
+	t := reflect.TypeOf(key)
+	if t == nil {
+		return "<nil>"
+	}
+	if t.Kind() == reflect.Struct && t.NumField() == 0 {
+		return t.String()
+	}
+	return fmt.Sprintf("%s(%#v)", t, key)
+}
+
+// formatValue prints a value, scenes and functions (such as lazy values) are only named by their type.
+func formatValue(value any) string {
+	if _, isCtx := value.(ogContext.Context); isCtx || (value != nil && reflect.TypeOf(value).Kind() == reflect.Func) {
+		return fmt.Sprintf("<%T>", value)
+	}
+	return fmt.Sprintf("%#v", value)
+}
+
+// formatKeys names keys with formatKey, ordered by name.
+func formatKeys(keys []any) []string {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, formatKey(key))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortKeys orders keys by their formatted name so output is stable.
+func sortKeys(keys []any) {
+	sort.Slice(keys, func(i, j int) bool {
+		return formatKey(keys[i]) < formatKey(keys[j])
+	})
+}
+
+// Dump prints every key a scene resolves with its value, one "key = value" line per key ordered by key name.
+// Keys are named by their type, e.g. scene.RequestIDKey or string("tenant"). An empty string is returned if ctx
+// isn't backed by a Scene.
+func Dump(ctx ogContext.Context) string {
+	scene := GetScene(ctx)
+	if scene == nil {
+		return ""
+	}
+	values := make(map[any]any)
+	scene.Range(func(key, value any) bool {
+		values[key] = value
+		return true
+	})
+	keys := keysOf(values)
+	sortKeys(keys)
+	var sb strings.Builder
+	for _, key := range keys {
+		sb.WriteString(formatKey(key))
+		sb.WriteString(" = ")
+		sb.WriteString(formatValue(values[key]))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// DefaultsDiff lists how the values of a scene differ from the current defaults of its factory.
+// Keys are ordered by name, the request ID and scene reference every scene has are left out.
+type DefaultsDiff struct {
+	// Added keys have a value on the scene but no factory default
+	Added []any
+	// Changed keys resolve to a different value on the scene than the factory default
+	Changed []any
+	// Missing keys have a factory default the scene doesn't resolve, e.g. a default stored after the scene was created
+	Missing []any
+}
+
+// Empty reports whether the scene matches the factory defaults.
+func (d DefaultsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Missing) == 0
+}
+
+func (d DefaultsDiff) String() string {
+	var sb strings.Builder
+	for _, section := range []struct {
+		prefix string
+		keys   []any
+	}{{"+ ", d.Added}, {"~ ", d.Changed}, {"- ", d.Missing}} {
+		for _, key := range section.keys {
+			sb.WriteString(section.prefix)
+			sb.WriteString(formatKey(key))
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// DiffDefaults compares the values a scene resolves to the current defaults of the factory that created it.
+// An empty diff is returned if ctx isn't backed by a Scene.
+func DiffDefaults(ctx ogContext.Context) DefaultsDiff {
+	var diff DefaultsDiff
+	factory := getFactory(ctx)
+	if factory == nil {
+		return diff
+	}
+	defaults := make(map[any]any)
+	for _, ancestor := range factory.lineage() {
+		for key, value := range ancestor.defaultValues() {
+			defaults[key] = value
+		}
+	}
+	values := make(map[any]any)
+	GetScene(ctx).Range(func(key, value any) bool {
+		values[key] = value
+		return true
+	})
+	delete(values, RequestIDKey{})
+	delete(values, ContextRef{})
+	for key, value := range values {
+		defaultValue, found := defaults[key]
+		if !found {
+			diff.Added = append(diff.Added, key)
+		} else if !sameValue(value, defaultValue) {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range defaults {
+		if _, found := values[key]; !found {
+			diff.Missing = append(diff.Missing, key)
+		}
+	}
+	sortKeys(diff.Added)
+	sortKeys(diff.Changed)
+	sortKeys(diff.Missing)
+	return diff
+}
+
+// sameValue compares values deeply, functions are the same if they point to the same code.
+func sameValue(a, b any) bool {
+	if a != nil && b != nil && reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Kind() == reflect.Func {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	return reflect.DeepEqual(a, b)
+}
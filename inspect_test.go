@@ -0,0 +1,193 @@
+package scene_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type inspectKey struct{}
+
+func TestContext_Range(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+		PoolContexts:      true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault("region", "us")
+	factory.StoreDefault("level", "info")
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	ctx.Store("level", "debug")
+	ctx.Store(inspectKey{}, 1)
+
+	values := map[any]any{}
+	ctx.Range(func(key, value any) bool {
+		values[key] = value
+		// The scene can be used from inside fn
+		ctx.Store("inside", true)
+		return true
+	})
+	require.Equal(t, "us", values["region"])
+	require.Equal(t, "debug", values["level"])
+	require.Equal(t, 1, values[inspectKey{}])
+	require.Equal(t, scene.GetRequestID(ctx), values[scene.RequestIDKey{}])
+	require.Contains(t, ctx.Keys(), "inside")
+	require.Len(t, ctx.Keys(), len(values)+1)
+
+	var calls int
+	ctx.Range(func(key, value any) bool {
+		calls++
+		return false
+	})
+	require.Equal(t, 1, calls)
+
+	view := ctx.With("region", "eu")
+	ctx.Scoped(func(scoped scene.Context) {
+		scoped.Store("scoped", true)
+		require.Contains(t, scoped.Keys(), "scoped")
+	})
+	require.NotContains(t, ctx.Keys(), "scoped")
+	viewValues := map[any]any{}
+	view.Range(func(key, value any) bool {
+		viewValues[key] = value
+		return true
+	})
+	require.Equal(t, "eu", viewValues["region"])
+	require.Equal(t, "debug", viewValues["level"])
+
+	ctx.Complete()
+	require.Empty(t, ctx.Keys())
+}
+
+func TestContext_RangeInherited(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier:   "Test",
+		MaxTTL:              scene.NoTTL,
+		LogOutput:           logger,
+		InheritParentValues: true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault("region", "us")
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	child, err := ctx.Spawn(scene.RunForever)
+	require.NoError(t, err)
+	defer child.Complete()
+	ctx.Store("region", "eu")
+	ctx.Store(inspectKey{}, "parent")
+	child.Delete("region")
+	values := map[any]any{}
+	child.Range(func(key, value any) bool {
+		values[key] = value
+		return true
+	})
+	// Range agrees with Lookup on inherited values, deleted keys and values the child overrides
+	require.Equal(t, "parent", values[inspectKey{}])
+	require.NotContains(t, values, "region")
+	require.Equal(t, scene.GetRequestID(child), values[scene.RequestIDKey{}])
+	for key, value := range values {
+		require.Equal(t, child.Value(key), value)
+	}
+}
+
+func TestDump(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault("region", "us")
+	factory.StoreDefault("lazy", scene.LazyValue(func(ctx scene.Context) (any, scene.CompleteFunc) {
+		return 1, nil
+	}))
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	ctx.Store(inspectKey{}, 5)
+
+	dump := scene.Dump(ctx)
+	require.Contains(t, dump, "scene_test.inspectKey = 5\n")
+	require.Contains(t, dump, `string("region") = "us"`+"\n")
+	require.Contains(t, dump, `scene.RequestIDKey = "`+scene.GetRequestID(ctx)+`"`)
+	require.Contains(t, dump, "scene.ContextRef = <*scene.context>\n")
+	require.Contains(t, dump, `string("lazy") = <scene.LazyValue>`)
+	require.Equal(t, "", scene.Dump(context.Background()))
+}
+
+func TestDiffDefaults(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault("region", "us")
+	factory.StoreDefault("level", "info")
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	require.True(t, scene.DiffDefaults(ctx).Empty())
+
+	ctx.Store("level", "debug")
+	ctx.Store("region", "us")
+	ctx.Store(inspectKey{}, 1)
+	factory.StoreDefault("late", true)
+	diff := scene.DiffDefaults(ctx)
+	require.Equal(t, []any{inspectKey{}}, diff.Added)
+	require.Equal(t, []any{"level"}, diff.Changed)
+	require.Equal(t, []any{"late"}, diff.Missing)
+	require.Equal(t, "+ scene_test.inspectKey\n~ string(\"level\")\n- string(\"late\")\n", diff.String())
+}
+
+func TestContext_TimeoutDebugLog(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Millisecond * 10,
+		LogOutput:         logger,
+		DebugMode:         true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	id := scene.GetRequestID(ctx)
+	ctx.Store(inspectKey{}, "stuck")
+	<-ctx.Done()
+	require.ErrorIs(t, ctx.Err(), scene.ErrTimeout)
+	logged := buf.String()
+	require.Contains(t, logged, "scene timed out")
+	require.Contains(t, logged, id)
+	require.Contains(t, logged, "scene_test.inspectKey")
+	// Values may be sensitive, only the keys are logged
+	require.NotContains(t, logged, "stuck")
+	require.Contains(t, logged, "startedBy")
+}
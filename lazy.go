@@ -0,0 +1,80 @@
+package scene
+
+import "sync"
+
+// LazyValue builds a per-scene value the first time its key is looked up on a scene.
+// The value is cached on the scene, cleanup (optional) is deferred on the scene only if the value was built.
+//
+//	Lazy values are not built once a scene is completing and a built value is dropped from the scene before its
+//	cleanup runs, Value returns nil for them instead and Lookup reports them missing.
+type LazyValue func(ctx Context) (value any, cleanup CompleteFunc)
+
+// lazyEntry makes sure a LazyValue is only built once per scene, even with concurrent lookups.
+type lazyEntry struct {
+	once  sync.Once
+	value any
+}
+
+// StoreLazy stores a LazyValue for key on a scene, it is built on the first lookup of key.
+func StoreLazy(ctx Context, key any, build LazyValue) {
+	ctx.Store(key, build)
+}
+
+// StoreLazyDefault stores a LazyValue for key in all new Scenes created in a factory, generally from a provider's
+// OnFactoryMount. Every scene builds its own value on the first lookup of key, scenes that never look it up don't.
+func StoreLazyDefault(valuer FactoryDefaultValuer, key any, build LazyValue) {
+	valuer.StoreDefault(key, build)
+}
+
+// resolveLazy builds (or gets the already built) value of a LazyValue.
+// The lock is not held while building so the constructor can use the scene.
+// ErrComplete is returned if the scene started completing, its cleanup may already have released the value.
+func (c *context) resolveLazy(generation uint64, key any, build LazyValue) (any, error) {
+	c.mu.Lock()
+	if !c.live(generation) {
+		c.mu.Unlock()
+		return nil, ErrComplete
+	}
+	if c.lazy == nil {
+		c.lazy = make(map[any]*lazyEntry)
+	}
+	entry, found := c.lazy[key]
+	if !found {
+		entry = &lazyEntry{}
+		c.lazy[key] = entry
+	}
+	self := c.self
+	c.mu.Unlock()
+	entry.once.Do(func() {
+		var cleanup CompleteFunc
+		entry.value, cleanup = build(self)
+		c.mu.Lock()
+		if !c.live(generation) {
+			c.mu.Unlock()
+			// The scene started completing while the value was built, so the cleanup can't be deferred anymore
+			if cleanup != nil {
+				cleanup(self, self.Err())
+			}
+			return
+		}
+		if cleanup != nil {
+			c.onComplete = append(c.onComplete, func(ctx Context, completeErr error) {
+				// Drop the value before releasing it, so callbacks that run afterward can't get it
+				c.mu.Lock()
+				_, _ = c.deleteLocked(key)
+				c.mu.Unlock()
+				cleanup(ctx, completeErr)
+			})
+		}
+		// Cache the value on the scene, replacing the LazyValue if it was stored with StoreLazy
+		c.contextValues[key] = entry.value
+		c.mu.Unlock()
+	})
+	// Waiters (and the builder) re-check the scene, it may have completed and run the cleanup in the meantime
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.live(generation) {
+		return nil, ErrComplete
+	}
+	return entry.value, nil
+}
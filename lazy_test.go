@@ -0,0 +1,163 @@
+package scene_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type lazyConnKey struct{}
+
+type lazyConn struct {
+	closed bool
+}
+
+type lazyProvider struct {
+	scene.BaseProvider
+	built  *atomic.Int32
+	closed *atomic.Int32
+}
+
+func (l lazyProvider) OnFactoryMount(valuer scene.FactoryDefaultValuer) {
+	scene.StoreLazyDefault(valuer, lazyConnKey{}, func(ctx scene.Context) (any, scene.CompleteFunc) {
+		l.built.Add(1)
+		conn := &lazyConn{}
+		return conn, func(ctx scene.Context, completeErr error) {
+			conn.closed = true
+			l.closed.Add(1)
+		}
+	})
+}
+
+func TestLazyValue(t *testing.T) {
+	var built, closed atomic.Int32
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, lazyProvider{built: &built, closed: &closed})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	t.Run("never used", func(t *testing.T) {
+		built.Store(0)
+		closed.Store(0)
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		ctx.Store("other", true)
+		ctx.Complete()
+		require.Equal(t, int32(0), built.Load())
+		require.Equal(t, int32(0), closed.Load())
+	})
+	t.Run("built once", func(t *testing.T) {
+		built.Store(0)
+		closed.Store(0)
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		var wg sync.WaitGroup
+		conns := make([]*lazyConn, 10)
+		for k := range conns {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				conns[k] = ctx.Value(lazyConnKey{}).(*lazyConn)
+			}(k)
+		}
+		wg.Wait()
+		for _, conn := range conns {
+			require.Same(t, conns[0], conn)
+		}
+		require.Equal(t, int32(1), built.Load())
+		ctx.Complete()
+		require.True(t, conns[0].closed)
+		require.Equal(t, int32(1), closed.Load())
+	})
+	t.Run("per scene", func(t *testing.T) {
+		built.Store(0)
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		ctx2, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx2.Complete()
+		require.NotSame(t, ctx.Value(lazyConnKey{}), ctx2.Value(lazyConnKey{}))
+		require.Equal(t, int32(2), built.Load())
+		_, isLazy := factory.GetDefault(lazyConnKey{}).(scene.LazyValue)
+		require.True(t, isLazy)
+	})
+	t.Run("store lazy", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		var cleaned bool
+		scene.StoreLazy(ctx, "greeting", func(ctx scene.Context) (any, scene.CompleteFunc) {
+			return "hello " + scene.GetRequestID(ctx), nil
+		})
+		scene.StoreLazy(ctx, "unused", func(ctx scene.Context) (any, scene.CompleteFunc) {
+			t.Fatal("unused values are never built")
+			return nil, nil
+		})
+		ctx.Defer(func(ctx scene.Context, completeErr error) {
+			// Lazy values are not built while the scene completes
+			require.Nil(t, ctx.Value("unused"))
+			cleaned = true
+		})
+		require.Equal(t, "hello "+scene.GetRequestID(ctx), ctx.Value("greeting"))
+		ctx.Complete()
+		require.True(t, cleaned)
+	})
+	t.Run("released", func(t *testing.T) {
+		closed.Store(0)
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		// Registered before the value is built, so it runs after the value's cleanup
+		ctx.Defer(func(ctx scene.Context, completeErr error) {
+			require.Nil(t, ctx.Value(lazyConnKey{}))
+			_, found := ctx.Lookup(lazyConnKey{})
+			require.False(t, found)
+		})
+		conn := ctx.Value(lazyConnKey{}).(*lazyConn)
+		ctx.Complete()
+		require.True(t, conn.closed)
+		require.Equal(t, int32(1), closed.Load())
+	})
+	t.Run("completed while building", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		building, release := make(chan struct{}), make(chan struct{})
+		var cleaned atomic.Bool
+		scene.StoreLazy(ctx, "slow", func(ctx scene.Context) (any, scene.CompleteFunc) {
+			close(building)
+			<-release
+			return "value", func(ctx scene.Context, completeErr error) {
+				cleaned.Store(true)
+			}
+		})
+		values := make([]any, 3)
+		var wg sync.WaitGroup
+		for k := range values {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				if k > 0 {
+					<-building
+				}
+				values[k] = ctx.Value("slow")
+			}(k)
+		}
+		<-building
+		ctx.Complete()
+		close(release)
+		wg.Wait()
+		// The builder and every waiter see the scene completed, the value was already cleaned up
+		require.Equal(t, []any{nil, nil, nil}, values)
+		require.True(t, cleaned.Load())
+	})
+}
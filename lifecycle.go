@@ -0,0 +1,169 @@
+package scene
+
+import (
+	"fmt"
+	"time"
+)
+
+// LifecycleState is the stage of a factory's life, it only ever moves forward.
+type LifecycleState int32
+
+const (
+	// StateRunning accepts new contexts.
+	StateRunning LifecycleState = iota
+	// StateDraining rejects new contexts and waits for open ones to complete.
+	StateDraining
+	// StateUnmounting is unmounting providers.
+	StateUnmounting
+	// StateStopped has finished shutting down.
+	StateStopped
+)
+
+func (s LifecycleState) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateDraining:
+		return "draining"
+	case StateUnmounting:
+		return "unmounting"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// LifecycleHook is called when a factory enters a lifecycle state.
+type LifecycleHook func(factory *Factory)
+
+// State gets the current lifecycle state of the factory.
+func (factory *Factory) State() LifecycleState {
+	return LifecycleState(factory.state.Load())
+}
+
+// OnShutdownStart registers a hook that runs once the factory stops accepting new contexts.
+// Hooks registered after the shutdown started run immediately.
+func (factory *Factory) OnShutdownStart(fn LifecycleHook) {
+	factory.addHook(StateDraining, fn)
+}
+
+// OnDrained registers a hook that runs once open contexts have completed (or been force-completed),
+// before any provider is unmounted.
+// Hooks registered after this point run immediately.
+func (factory *Factory) OnDrained(fn LifecycleHook) {
+	factory.addHook(StateUnmounting, fn)
+}
+
+// OnUnmounted registers a hook that runs once every provider has been unmounted.
+// Hooks registered after this point run immediately.
+func (factory *Factory) OnUnmounted(fn LifecycleHook) {
+	factory.addHook(StateStopped, fn)
+}
+
+func (factory *Factory) addHook(state LifecycleState, fn LifecycleHook) {
+	factory.lifecycleLock.Lock()
+	if factory.State() < state {
+		factory.hooks[state] = append(factory.hooks[state], fn)
+		factory.lifecycleLock.Unlock()
+		return
+	}
+	factory.lifecycleLock.Unlock()
+	factory.runHook(state, fn)
+}
+
+// setState moves the factory to state and runs every hook registered for it in order.
+func (factory *Factory) setState(state LifecycleState) {
+	factory.lifecycleLock.Lock()
+	factory.state.Store(int32(state))
+	hooks := factory.hooks[state]
+	delete(factory.hooks, state)
+	factory.lifecycleLock.Unlock()
+	for _, fn := range hooks {
+		factory.runHook(state, fn)
+	}
+}
+
+func (factory *Factory) runHook(state LifecycleState, fn LifecycleHook) {
+	defer func() {
+		// A broken hook must not stop the rest of the shutdown
+		if r := recover(); r != nil {
+			factory.factoryLogger.Error().
+				Str("factoryIdentifier", factory.factoryIdentifier).
+				Str("state", state.String()).
+				Err(fmt.Errorf("panic in lifecycle hook: %v", r)).
+				Send()
+		}
+	}()
+	fn(factory)
+}
+
+// shutdownNotice is the "please finish" signal a context opted into with NotifyShutdown.
+type shutdownNotice struct {
+	c     chan struct{}
+	grace time.Duration
+	fired bool
+	timer *time.Timer
+}
+
+// NotifyShutdown opts a long-lived scene into a "please finish" signal.
+// The returned channel is closed as soon as the factory starts shutting down, the scene then has grace to complete
+// on its own before it is completed with ErrShutdownInProgress.
+// Calling this again on the same scene returns the same channel and updates the grace period if it has not fired.
+// A nil channel is returned if ctx is not backed by a Scene.
+func NotifyShutdown(ctx Context, grace time.Duration) <-chan struct{} {
+	c, generation, ok := unwrapScene(ctx)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.current(generation) {
+		return nil
+	}
+	if c.notice == nil {
+		c.notice = &shutdownNotice{
+			c: make(chan struct{}),
+		}
+	}
+	if c.notice.fired {
+		return c.notice.c
+	}
+	c.notice.grace = grace
+	if c.isComplete {
+		return c.notice.c
+	}
+	if c.factory.closed.Load() {
+		c.fireNotice()
+	}
+	return c.notice.c
+}
+
+// fireNotice closes the notice channel and starts the grace period, c.mu must be held.
+func (c *context) fireNotice() {
+	if c.notice == nil || c.notice.fired {
+		return
+	}
+	c.notice.fired = true
+	close(c.notice.c)
+	generation := c.generation.Load()
+	c.notice.timer = time.AfterFunc(c.notice.grace, func() {
+		c.completeWithError(generation, ErrShutdownInProgress)
+	})
+}
+
+// notifyShutdown fires the notice of every open context that opted in.
+func (factory *Factory) notifyShutdown() {
+	factory.openLock.Lock()
+	open := make([]*context, 0, len(factory.open))
+	for ctx := range factory.open {
+		open = append(open, ctx)
+	}
+	factory.openLock.Unlock()
+	for _, ctx := range open {
+		ctx.mu.Lock()
+		if !ctx.isComplete {
+			ctx.fireNotice()
+		}
+		ctx.mu.Unlock()
+	}
+}
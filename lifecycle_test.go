@@ -0,0 +1,114 @@
+package scene_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+func TestFactory_Lifecycle(t *testing.T) {
+	var order []string
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, unmountProvider{name: "db", order: &order})
+	require.Equal(t, scene.StateRunning, factory.State())
+	factory.OnUnmounted(func(factory *scene.Factory) {
+		require.Equal(t, scene.StateStopped, factory.State())
+		order = append(order, "unmounted")
+	})
+	factory.OnDrained(func(factory *scene.Factory) {
+		require.Equal(t, scene.StateUnmounting, factory.State())
+		require.Equal(t, 0, factory.OpenContexts())
+		order = append(order, "drained")
+	})
+	factory.OnShutdownStart(func(factory *scene.Factory) {
+		require.Equal(t, scene.StateDraining, factory.State())
+		order = append(order, "start")
+	})
+	factory.OnShutdownStart(func(factory *scene.Factory) {
+		panic("broken hook")
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	ctx.Defer(func(ctx scene.Context, completeErr error) {
+		order = append(order, "complete")
+	})
+	factory.OnShutdownStart(func(factory *scene.Factory) {
+		go ctx.Complete()
+	})
+	require.True(t, factory.Shutdown(time.Second))
+	require.Equal(t, scene.StateStopped, factory.State())
+	require.Equal(t, []string{"start", "complete", "drained", "db", "unmounted"}, order)
+	require.Contains(t, buf.String(), "panic in lifecycle hook")
+
+	// Hooks registered once the state has passed run immediately
+	var late bool
+	factory.OnDrained(func(factory *scene.Factory) {
+		late = true
+	})
+	require.True(t, late)
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	newFactory := func() *scene.Factory {
+		buf := tsbuffer.New()
+		logger := zerolog.New(buf)
+		factory, _ := scene.NewSceneFactory(scene.Config{
+			FactoryIdentifier: "Test",
+			MaxTTL:            scene.NoTTL,
+			LogOutput:         logger,
+		})
+		return factory
+	}
+	t.Run("finishes within grace", func(t *testing.T) {
+		factory := newFactory()
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		notice := scene.NotifyShutdown(ctx, time.Second)
+		require.Equal(t, notice, scene.NotifyShutdown(ctx, time.Second))
+		go func() {
+			<-notice
+			ctx.Complete()
+		}()
+		require.True(t, factory.Shutdown(time.Second))
+		require.ErrorIs(t, ctx.Err(), scene.ErrComplete)
+	})
+	t.Run("force-completed after grace", func(t *testing.T) {
+		factory := newFactory()
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		notice := scene.NotifyShutdown(ctx, time.Millisecond*10)
+		started := time.Now()
+		require.True(t, factory.Shutdown(time.Second))
+		require.Less(t, time.Since(started), time.Second)
+		<-notice
+		require.ErrorIs(t, ctx.Err(), scene.ErrShutdownInProgress)
+	})
+	t.Run("opt in after shutdown started", func(t *testing.T) {
+		factory := newFactory()
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		factory.OnShutdownStart(func(factory *scene.Factory) {
+			notice := scene.NotifyShutdown(ctx, 0)
+			<-notice
+		})
+		require.True(t, factory.Shutdown(time.Second))
+		require.ErrorIs(t, ctx.Err(), scene.ErrShutdownInProgress)
+	})
+	t.Run("not opted in", func(t *testing.T) {
+		factory := newFactory()
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		require.False(t, factory.Shutdown(time.Millisecond*10))
+		require.NoError(t, ctx.Err())
+	})
+}
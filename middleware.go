@@ -2,14 +2,28 @@ package scene
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultRetryAfter is sent in the Retry-After header of requests rejected while the factory shuts down.
+const DefaultRetryAfter = 10 * time.Second
+
+// DeadlineHeader is the response header the effective request deadline is echoed in.
+const DeadlineHeader = "X-Request-Deadline"
+
 type CtxHTTPHeaderKey struct{}
 type CtxHTTPEncoder struct{}
+type CtxHTTPDecoder struct{}
+type CtxHTTPRouteKey struct{}
 
 var ErrEncoderProviderRequired = errors.New("encoder provider must return an encoder")
 var ErrOnRequestIsRequired = errors.New("onRequest is a required function, even if its empty")
+var ErrAlreadyEncoded = errors.New("response has already been encoded")
+var ErrWriteAfterEncode = errors.New("write to response after it was encoded")
 
 // NewHTTPMiddleware creates a new middleware handler for a given factory.
 //
@@ -17,18 +31,132 @@ var ErrOnRequestIsRequired = errors.New("onRequest is a required function, even
 //		Note: Ctx may be nil in error cases.
 //	onRequestHook allows you to hook the request before it starts to serve anything from the middleware.
 //		Note: The hook can nil if it's not used.
-func NewHTTPMiddleware(factory *Factory, encoderProvider EncoderProvider, onRequestHook RequestHook) (*HTTPMiddleware, error) {
+//	options allow optional behavior such as request body decoding to be enabled.
+func NewHTTPMiddleware(factory *Factory, encoderProvider EncoderProvider, onRequestHook RequestHook, options ...HTTPOption) (*HTTPMiddleware, error) {
 	if encoderProvider == nil {
 		return nil, ErrEncoderProviderRequired
 	}
 	if onRequestHook == nil {
 		return nil, ErrOnRequestIsRequired
 	}
-	return &HTTPMiddleware{
-		factory:         factory,
-		onRequestHook:   onRequestHook,
-		encoderProvider: encoderProvider,
-	}, nil
+	middleware := &HTTPMiddleware{
+		factory:       factory,
+		onRequestHook: onRequestHook,
+		options: httpOptions{
+			encoderProvider: encoderProvider,
+		},
+	}
+	for _, option := range options {
+		option(&middleware.options)
+	}
+	if middleware.options.encoderProvider == nil {
+		return nil, ErrEncoderProviderRequired
+	}
+	return middleware, nil
+}
+
+// HTTPOption configures optional behavior of the HTTP middleware.
+// Options can be set for the whole middleware or overridden per route through HTTPMiddleware.With.
+type HTTPOption func(options *httpOptions)
+
+type httpOptions struct {
+	encoderProvider   EncoderProvider
+	decoderProvider   DecoderProvider
+	maxBodyBytes      int64
+	ttl               time.Duration
+	hasTTL            bool
+	ttlHeader         string
+	maxClientTTL      time.Duration
+	terminationPolicy TerminationPolicy
+	retryAfter        time.Duration
+}
+
+// WithEncoderProvider overrides the encoder provider the middleware was created with.
+func WithEncoderProvider(encoderProvider EncoderProvider) HTTPOption {
+	return func(options *httpOptions) {
+		options.encoderProvider = encoderProvider
+	}
+}
+
+// WithTTL overrides the factory's MaxTTL for requests served by the middleware, NoTTL disables the deadline.
+func WithTTL(ttl time.Duration) HTTPOption {
+	return func(options *httpOptions) {
+		options.ttl = ttl
+		options.hasTTL = true
+	}
+}
+
+// WithRetryAfter sets the Retry-After sent with the 503 returned once the factory has started shutting down.
+func WithRetryAfter(retryAfter time.Duration) HTTPOption {
+	return func(options *httpOptions) {
+		options.retryAfter = retryAfter
+	}
+}
+
+// WithClientTTL lets clients request their own TTL through a header such as "Request-Timeout".
+// The header can be in seconds ("2.5") or a Go duration ("500ms"), anything above maxTTL is capped to maxTTL.
+// Invalid, non-finite or non-positive values fall back to the route's TTL.
+// maxTTL must be positive, clients can't request an unbounded TTL so the header is ignored otherwise.
+func WithClientTTL(header string, maxTTL time.Duration) HTTPOption {
+	return func(options *httpOptions) {
+		options.ttlHeader = header
+		options.maxClientTTL = maxTTL
+	}
+}
+
+// requestTTL resolves the TTL for a single request.
+func (o httpOptions) requestTTL(factory *Factory, request *http.Request) time.Duration {
+	ttl := factory.requestTTL
+	if o.hasTTL {
+		ttl = o.ttl
+	}
+	if o.ttlHeader == "" || o.maxClientTTL <= 0 {
+		return ttl
+	}
+	clientTTL := parseClientTTL(request.Header.Get(o.ttlHeader))
+	if clientTTL <= 0 {
+		return ttl
+	}
+	if clientTTL > o.maxClientTTL {
+		return o.maxClientTTL
+	}
+	return clientTTL
+}
+
+func parseClientTTL(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return 0
+		}
+		// Converting an out of range float to a Duration is undefined, large values are capped by the caller anyway
+		if seconds >= float64(math.MaxInt64)/float64(time.Second) {
+			return math.MaxInt64
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if ttl, err := time.ParseDuration(value); err == nil {
+		return ttl
+	}
+	return 0
+}
+
+// WithDecoderProvider enables request body decoding, the decoder is available through GetDecoder and DecodeRequest.
+func WithDecoderProvider(decoderProvider DecoderProvider) HTTPOption {
+	return func(options *httpOptions) {
+		options.decoderProvider = decoderProvider
+	}
+}
+
+// WithMaxBodyBytes limits how much of the request body can be read, reads past the limit fail with a 413.
+// A limit of 0 leaves the body unbounded.
+func WithMaxBodyBytes(limit int64) HTTPOption {
+	return func(options *httpOptions) {
+		options.maxBodyBytes = limit
+	}
 }
 
 // EncoderProvider allows for the correct encoder to be returned based on the provided http request.
@@ -44,58 +172,199 @@ type ResponseEncoder interface {
 	Encode(obj any) error
 }
 
+// PendingErrorReporter is implemented by encoders that can tell whether errors were added but not encoded yet.
+// When the chain writes nothing the middleware sends an empty 204 only if the encoder reports no pending errors,
+// encoders that don't implement it are always asked to encode so errors are never dropped.
+type PendingErrorReporter interface {
+	HasPendingErrors() bool
+}
+
 type HTTPMiddleware struct {
-	factory         *Factory
-	encoderProvider EncoderProvider
-	onRequestHook   RequestHook
-	next            []http.Handler
+	factory       *Factory
+	onRequestHook RequestHook
+	options       httpOptions
+	next          []http.Handler
+}
+
+// Router is satisfied by http.ServeMux and most third party routers.
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// With creates a copy of the middleware with the options overridden, this is used to build per-route chains.
+// Handlers added with Next are not copied.
+func (c *HTTPMiddleware) With(options ...HTTPOption) *HTTPMiddleware {
+	middleware := &HTTPMiddleware{
+		factory:       c.factory,
+		onRequestHook: c.onRequestHook,
+		options:       c.options,
+	}
+	for _, option := range options {
+		option(&middleware.options)
+	}
+	return middleware
+}
+
+// Chain creates a handler that runs handlers in sequence inside a new scene.
+// Each chain is independent from the handlers added with Next, so one middleware can serve many routes.
+func (c *HTTPMiddleware) Chain(handlers ...http.Handler) http.Handler {
+	return &chain{
+		middleware: c,
+		handlers:   handlers,
+	}
+}
+
+// Handle registers a chain on a router for the given pattern.
+// Go 1.22 http.ServeMux patterns such as "GET /items/{id}" are passed through untouched,
+// and the pattern is available to handlers with GetRoutePattern.
+func (c *HTTPMiddleware) Handle(router Router, pattern string, handlers ...http.Handler) {
+	router.Handle(pattern, &chain{
+		middleware: c,
+		handlers:   handlers,
+		pattern:    pattern,
+	})
+}
+
+type chain struct {
+	middleware *HTTPMiddleware
+	handlers   []http.Handler
+	pattern    string
+}
+
+func (ch *chain) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ch.middleware.serve(writer, request, ch.handlers, ch.pattern)
+}
+
+// GetRoutePattern gets the pattern a chain was registered with through HTTPMiddleware.Handle.
+func GetRoutePattern(ctx Context) string {
+	pattern, _ := ctx.Value(CtxHTTPRouteKey{}).(string)
+	return pattern
 }
 
 type capturingWriter struct {
 	http.ResponseWriter
 	statusCode int
+	// Has the status line and headers been sent
+	wroteHeader bool
+	// Has an encoder finished writing the response
+	encoded bool
 }
 
 func (cw *capturingWriter) SetStatusCode(status int) {
 	cw.statusCode = status
 }
 
+// WriteHeader sends the status code once, repeated calls are ignored rather than producing superfluous writes.
 func (cw *capturingWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
 	cw.ResponseWriter.WriteHeader(statusCode)
 	cw.statusCode = statusCode
 }
 
+func (cw *capturingWriter) Write(data []byte) (int, error) {
+	if cw.encoded {
+		return 0, ErrWriteAfterEncode
+	}
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+func (cw *capturingWriter) state() ResponseState {
+	return ResponseState{
+		StatusCode: cw.statusCode,
+		Committed:  cw.wroteHeader,
+		Encoded:    cw.encoded,
+	}
+}
+
+// Flush sends any buffered data to the client if the underlying writer supports it.
+func (cw *capturingWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (cw *capturingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// MarkEncoded flags a response writer provided by the middleware as fully encoded.
+// Encoders should call this once they finish writing, any further writes will fail with ErrWriteAfterEncode.
+// Writers that did not come from the middleware are ignored.
+func MarkEncoded(w http.ResponseWriter) {
+	for w != nil {
+		if cw, ok := w.(*capturingWriter); ok {
+			cw.encoded = true
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
 // ServeHTTP adds the mux handler for the go built-in http server to serve requests. It will invoke the next item
 // in the given chain when provided. Contexts will complete if they were not already completed/closed.
-// Any error in the chain will cause the chain to terminate.
-// Errors are defined as anything that sets a status code on the response writer >= 400.
-// HTTP redirects will also cause a termination of the chain.
+// The chain terminates based on the middleware's TerminationPolicy, by default this is any 300+ status code
+// (redirects and errors) written to the response writer. Handlers wrapped with AlwaysRun execute regardless.
+// If nothing in the chain writes a response, an empty 204 is sent unless errors were added to the encoder,
+// those are encoded instead.
 func (c HTTPMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	newCtx, err := c.factory.Wrap(request.Context())
+	c.serve(writer, request, c.next, "")
+}
+
+func (c HTTPMiddleware) serve(writer http.ResponseWriter, request *http.Request, handlers []http.Handler, pattern string) {
+	newCtx, err := c.factory.WrapWithTTL(request.Context(), c.options.requestTTL(c.factory, request))
 	if err != nil {
 		// handle what is generally a transient error from a server shutdown/restart
-		writer.WriteHeader(503)
-		writer.Header().Add("Retry-After", "10")
-		encoder := c.encoderProvider(nil, request)
-		encoder.AddError(errors.New("service temporarily unavailable"), 503)
-		_ = encoder.Encode(encoder)
+		retryAfter := c.options.retryAfter
+		if retryAfter <= 0 {
+			retryAfter = DefaultRetryAfter
+		}
+		writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		encoder := c.options.encoderProvider(nil, request)
+		encoder.SetWriter(nil, writer)
+		encoder.AddError(errors.New("service temporarily unavailable"), http.StatusServiceUnavailable)
+		_ = encoder.Encode(nil)
 		return
 	}
 	*request = *request.WithContext(newCtx)
 	newCtx.Store(CtxHTTPHeaderKey{}, request.Header)
-	out := c.encoderProvider(newCtx, request)
+	if pattern != "" {
+		newCtx.Store(CtxHTTPRouteKey{}, pattern)
+	}
+	out := c.options.encoderProvider(newCtx, request)
 	captureWriter := &capturingWriter{ResponseWriter: writer}
 	captureWriter.Header().Add("X-Request-ID", newCtx.Value(RequestIDKey{}).(string))
+	if deadline, ok := newCtx.Deadline(); ok {
+		captureWriter.Header().Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	}
 	// Set the encoder to the correct output
 	out.SetWriter(newCtx, captureWriter)
 	newCtx.Store(CtxHTTPEncoder{}, out)
+	if c.options.maxBodyBytes > 0 && request.Body != nil {
+		request.Body = http.MaxBytesReader(captureWriter, request.Body, c.options.maxBodyBytes)
+	}
+	if c.options.decoderProvider != nil {
+		newCtx.Store(CtxHTTPDecoder{}, c.options.decoderProvider(newCtx, request))
+	}
 	if c.onRequestHook != nil {
 		c.onRequestHook(newCtx, request, out)
 	}
-	for _, handler := range c.next {
-		handler.ServeHTTP(captureWriter, request)
-		if captureWriter.statusCode >= 300 {
-			break
+	runChain(newCtx, captureWriter, request, handlers, c.options.terminationPolicy)
+	if !captureWriter.wroteHeader {
+		if reporter, ok := out.(PendingErrorReporter); ok && !reporter.HasPendingErrors() {
+			captureWriter.WriteHeader(http.StatusNoContent)
+		} else {
+			_ = out.Encode(nil)
 		}
 	}
 	newCtx.Complete()
@@ -103,7 +372,7 @@ func (c HTTPMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 
 // Next adds a new handler to run in sequence after this one fires.
 //
-//	Any 400+ status code to the writer will stop the chain.
+//	The chain stops based on the TerminationPolicy, by default on any 300+ status code.
 func (c *HTTPMiddleware) Next(handler http.Handler) {
 	c.next = append(c.next, handler)
 }
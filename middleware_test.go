@@ -2,7 +2,9 @@ package scene_test
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"github.com/weisbartb/scene"
 	"github.com/weisbartb/scene/encoders"
@@ -210,3 +212,363 @@ func TestNewHTTPMiddleware(t *testing.T) {
 	enc.SetWriter(ctx, enc.GetWriter())
 	require.NoError(t, enc.Encode(nil))
 }
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"gzip":                      "gzip",
+		"gzip;q=0":                  "",
+		"gzip;q=0, deflate":         "deflate",
+		"deflate, gzip":             "gzip",
+		"deflate;q=1, gzip;q=0.5":   "deflate",
+		"*":                         "gzip",
+		"*;q=0.5, gzip;q=0":         "deflate",
+		"identity, gzip;q=0.5":      "",
+		"br":                        "",
+		"x-gzip":                    "gzip",
+		"gzip;q=banana, deflate":    "deflate",
+		"GZIP ; Q=0.8 , deflate;q=": "gzip",
+	}
+	for header, expected := range tests {
+		require.Equal(t, expected, encoders.NegotiateCompression(http.Header{"Accept-Encoding": []string{header}}), header)
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	t.Run("below threshold", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		w := encoders.NewCompressWriter(recorder, http.Header{"Accept-Encoding": []string{"gzip"}}, encoders.CompressionOptions{MinSize: 64})
+		w.WriteHeader(http.StatusCreated)
+		_, err := w.Write([]byte("small"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		require.Equal(t, "small", recorder.Body.String())
+	})
+	t.Run("above threshold", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("Vary", "Origin, accept-encoding")
+		w := encoders.NewCompressWriter(recorder, http.Header{"Accept-Encoding": []string{"deflate"}}, encoders.CompressionOptions{MinSize: 64})
+		payload := strings.Repeat("a", 128)
+		_, err := w.Write([]byte(payload[:32]))
+		require.NoError(t, err)
+		require.False(t, recorder.Flushed)
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		_, err = w.Write([]byte(payload[32:]))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, "deflate", recorder.Header().Get("Content-Encoding"))
+		require.Equal(t, []string{"Origin, accept-encoding"}, recorder.Header().Values("Vary"))
+		data, err := io.ReadAll(flate.NewReader(recorder.Body))
+		require.NoError(t, err)
+		require.Equal(t, payload, string(data))
+	})
+	t.Run("not negotiated", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		w := encoders.NewCompressWriter(recorder, http.Header{}, encoders.DefaultCompressionOptions)
+		_, err := w.Write([]byte("plain"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		require.Equal(t, "plain", recorder.Body.String())
+	})
+	t.Run("disabled", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		w := encoders.NewCompressWriter(recorder, http.Header{"Accept-Encoding": []string{"gzip"}}, encoders.CompressionOptions{Disabled: true})
+		_, err := w.Write([]byte("plain"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		require.Equal(t, "", recorder.Header().Get("Vary"))
+		require.Equal(t, "plain", recorder.Body.String())
+	})
+	t.Run("registered compressor", func(t *testing.T) {
+		encoders.RegisterCompressor("test-coding", func(w io.Writer) encoders.CompressionWriter {
+			return gzip.NewWriter(w)
+		})
+		recorder := httptest.NewRecorder()
+		w := encoders.NewCompressWriter(recorder, http.Header{"Accept-Encoding": []string{"gzip;q=0.5, test-coding"}}, encoders.DefaultCompressionOptions)
+		require.Equal(t, "test-coding", w.Encoding())
+		_, err := w.Write([]byte("custom"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, "test-coding", recorder.Header().Get("Content-Encoding"))
+		r, err := gzip.NewReader(recorder.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "custom", string(data))
+	})
+	t.Run("empty body", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		w := encoders.NewCompressWriter(recorder, http.Header{"Accept-Encoding": []string{"gzip"}}, encoders.DefaultCompressionOptions)
+		require.NoError(t, w.Close())
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		require.Zero(t, recorder.Body.Len())
+	})
+	t.Run("no content", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		w := encoders.NewCompressWriter(recorder, http.Header{"Accept-Encoding": []string{"gzip"}}, encoders.DefaultCompressionOptions)
+		w.WriteHeader(http.StatusNoContent)
+		w.Flush()
+		require.NoError(t, w.Close())
+		require.Equal(t, http.StatusNoContent, recorder.Code)
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		require.Zero(t, recorder.Body.Len())
+	})
+}
+
+func TestHTTPMiddleware_EncodeGuards(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	newMiddleware := func(t *testing.T, handlers ...http.Handler) *scene.HTTPMiddleware {
+		middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+			return encoders.NewJSONEncoder(request.Header, testWrapper{})
+		}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {})
+		require.NoError(t, err)
+		for _, handler := range handlers {
+			middleware.Next(handler)
+		}
+		return middleware
+	}
+	t.Run("double encode", func(t *testing.T) {
+		var secondErr, writeErr error
+		middleware := newMiddleware(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+			ctx := scene.GetScene(r.Context())
+			require.NoError(t, scene.GetEncoder(ctx).Encode("first"))
+			secondErr = scene.GetEncoder(ctx).Encode("second")
+			writer.WriteHeader(http.StatusTeapot)
+			_, writeErr = writer.Write([]byte("raw"))
+		}})
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		middleware.ServeHTTP(recorder, req)
+		require.ErrorIs(t, secondErr, scene.ErrAlreadyEncoded)
+		require.ErrorIs(t, writeErr, scene.ErrWriteAfterEncode)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		r, err := gzip.NewReader(recorder.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"data":"first"`)
+	})
+	t.Run("nothing encoded", func(t *testing.T) {
+		middleware := newMiddleware(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {}})
+		recorder := httptest.NewRecorder()
+		middleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusNoContent, recorder.Code)
+		require.Empty(t, recorder.Body.String())
+	})
+	t.Run("only errors added", func(t *testing.T) {
+		middleware := newMiddleware(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+			scene.GetEncoder(scene.GetScene(r.Context())).AddError(errors.New("bad input"), http.StatusBadRequest)
+		}})
+		recorder := httptest.NewRecorder()
+		middleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), `"statusCode":400`)
+	})
+	t.Run("raw handler response", func(t *testing.T) {
+		middleware := newMiddleware(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+			_, _ = writer.Write([]byte("raw"))
+		}})
+		recorder := httptest.NewRecorder()
+		middleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "raw", recorder.Body.String())
+	})
+}
+
+func TestHTTPMiddleware_Chains(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+		return encoders.NewJSONEncoder(request.Header, testWrapper{})
+	}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {})
+	require.NoError(t, err)
+	var calls []string
+	auth := testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "auth")
+		if r.Header.Get("Authorization") == "" {
+			ctx := scene.GetScene(r.Context())
+			scene.GetEncoder(ctx).AddError(errors.New("unauthorized"), http.StatusUnauthorized)
+			_ = scene.GetEncoder(ctx).Encode(nil)
+		}
+	}}
+	var deadline time.Duration
+	getItem := testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "get")
+		ctx := scene.GetScene(r.Context())
+		require.Equal(t, "GET /items/{id}", scene.GetRoutePattern(ctx))
+		dl, ok := ctx.Deadline()
+		require.True(t, ok)
+		deadline = time.Until(dl)
+		_ = scene.GetEncoder(ctx).Encode(r.PathValue("id"))
+	}}
+	upload := testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "upload")
+		ctx := scene.GetScene(r.Context())
+		_, ok := ctx.Deadline()
+		require.False(t, ok)
+		var body map[string]any
+		if scene.DecodeRequest(ctx, &body) == nil {
+			_ = scene.GetEncoder(ctx).Encode(body)
+			return
+		}
+		_ = scene.GetEncoder(ctx).Encode(nil)
+	}}
+	mux := http.NewServeMux()
+	middleware.Handle(mux, "GET /items/{id}", auth, getItem)
+	middleware.With(
+		scene.WithTTL(scene.NoTTL),
+		scene.WithMaxBodyBytes(16),
+		scene.WithDecoderProvider(encoders.NewContentTypeDecoderProvider(encoders.DecoderOptions{})),
+		scene.WithEncoderProvider(func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+			return encoders.NewJSONEncoderWithCompression(request.Header, testWrapper{}, encoders.CompressionOptions{Disabled: true})
+		}),
+	).Handle(mux, "POST /uploads", upload)
+	mux.Handle("/health", middleware.Chain(testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "health")
+	}}))
+
+	t.Run("path values", func(t *testing.T) {
+		calls = nil
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/items/42", nil)
+		req.Header.Set("Authorization", "token")
+		mux.ServeHTTP(recorder, req)
+		require.Equal(t, []string{"auth", "get"}, calls)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Contains(t, recorder.Body.String(), `"data":"42"`)
+		require.Greater(t, deadline, time.Duration(0))
+		require.LessOrEqual(t, deadline, time.Second)
+	})
+	t.Run("chain stops on error", func(t *testing.T) {
+		calls = nil
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/items/42", nil))
+		require.Equal(t, []string{"auth"}, calls)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+	t.Run("route options", func(t *testing.T) {
+		calls = nil
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader(`{"a":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		mux.ServeHTTP(recorder, req)
+		require.Equal(t, []string{"upload"}, calls)
+		require.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+		require.Contains(t, recorder.Body.String(), `"data":{"a":1}`)
+
+		recorder = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader(`{"a":"`+strings.Repeat("a", 32)+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+	t.Run("method mismatch", func(t *testing.T) {
+		calls = nil
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/uploads", nil))
+		require.Empty(t, calls)
+		require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+	t.Run("chain without pattern", func(t *testing.T) {
+		calls = nil
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+		require.Equal(t, []string{"health"}, calls)
+		require.Equal(t, http.StatusNoContent, recorder.Code)
+	})
+}
+
+func TestHTTPMiddleware_RequestTTL(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second * 30,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+		return encoders.NewJSONEncoder(request.Header, testWrapper{})
+	}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {},
+		scene.WithClientTTL("Request-Timeout", time.Second*10))
+	require.NoError(t, err)
+	var remaining time.Duration
+	handler := testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		ctx := scene.GetScene(r.Context())
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		remaining = time.Until(deadline)
+		_ = scene.GetEncoder(ctx).Encode(nil)
+	}}
+	serve := func(t *testing.T, handler http.Handler, timeout string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if timeout != "" {
+			req.Header.Set("Request-Timeout", timeout)
+		}
+		handler.ServeHTTP(recorder, req)
+		deadline, err := time.Parse(time.RFC3339Nano, recorder.Header().Get(scene.DeadlineHeader))
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(remaining), deadline, time.Millisecond*50)
+		return recorder
+	}
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		timeout  string
+		expected time.Duration
+	}{
+		{"factory default", middleware.Chain(handler), "", time.Second * 30},
+		{"seconds", middleware.Chain(handler), "2", time.Second * 2},
+		{"duration", middleware.Chain(handler), "250ms", time.Millisecond * 250},
+		{"capped", middleware.Chain(handler), "3600", time.Second * 10},
+		{"invalid", middleware.Chain(handler), "banana", time.Second * 30},
+		{"not a number", middleware.Chain(handler), "NaN", time.Second * 30},
+		{"infinite", middleware.Chain(handler), "+Inf", time.Second * 30},
+		{"out of range", middleware.Chain(handler), "1e300", time.Second * 10},
+		{"no cap", middleware.With(scene.WithClientTTL("Request-Timeout", 0)).Chain(handler), "5", time.Second * 30},
+		{"route ttl", middleware.With(scene.WithTTL(time.Millisecond * 100)).Chain(handler), "", time.Millisecond * 100},
+		{"client overrides route ttl", middleware.With(scene.WithTTL(time.Millisecond * 100)).Chain(handler), "1", time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serve(t, test.handler, test.timeout)
+			require.LessOrEqual(t, remaining, test.expected)
+			require.Greater(t, remaining, test.expected-time.Millisecond*50)
+		})
+	}
+	t.Run("no deadline", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		middleware.With(scene.WithTTL(scene.NoTTL), scene.WithClientTTL("", 0)).Chain(testHandler{call: func(writer http.ResponseWriter, r *http.Request) {}}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, "", recorder.Header().Get(scene.DeadlineHeader))
+	})
+}
@@ -0,0 +1,100 @@
+package scene
+
+import (
+	ogContext "context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrOnceTypeMismatch = errors.New("once key was already used for a different type")
+
+// OnceOption configures how Once caches errors.
+type OnceOption func(*onceOptions)
+
+type onceOptions struct {
+	retryOnError bool
+	errorTTL     time.Duration
+}
+
+// RetryOnError doesn't cache errors, the next caller runs the function again.
+func RetryOnError() OnceOption {
+	return func(options *onceOptions) {
+		options.retryOnError = true
+	}
+}
+
+// CacheErrorFor caches an error for ttl, callers after that run the function again.
+func CacheErrorFor(ttl time.Duration) OnceOption {
+	return func(options *onceOptions) {
+		options.errorTTL = ttl
+	}
+}
+
+type onceEntry struct {
+	// Holds a token while a caller computes or reads the entry, a channel so waiters can give up when ctx is done
+	lock      chan struct{}
+	done      bool
+	value     any
+	err       error
+	expiresAt time.Time
+}
+
+// Once computes a value at most once per scene for key, even with concurrent callers, e.g. a request's authorization
+// result or the loaded user profile. Callers that arrive while the value is being computed wait for the result,
+// or return ctx's error if ctx is done first.
+// By default errors are cached like values, see RetryOnError and CacheErrorFor.
+// Keys are separate from the values stored on the scene, results are cleared when the scene completes.
+// fn runs uncached if ctx is not backed by a Scene, a completed scene returns its error.
+func Once[T any](ctx ogContext.Context, key any, fn func() (T, error), options ...OnceOption) (T, error) {
+	var zero T
+	c, generation, ok := unwrapScene(ctx)
+	if !ok {
+		return fn()
+	}
+	var opts onceOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	c.mu.Lock()
+	if !c.live(generation) {
+		c.mu.Unlock()
+		return zero, ctx.Err()
+	}
+	if c.onces == nil {
+		c.onces = make(map[any]*onceEntry)
+	}
+	entry, found := c.onces[key]
+	if !found {
+		entry = &onceEntry{lock: make(chan struct{}, 1)}
+		c.onces[key] = entry
+	}
+	c.mu.Unlock()
+	select {
+	case entry.lock <- struct{}{}:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	defer func() {
+		<-entry.lock
+	}()
+	if !entry.done || (entry.err != nil && !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)) {
+		// A panic in fn leaves the entry undone, so the next caller tries again
+		value, err := fn()
+		if err != nil && opts.retryOnError {
+			return value, err
+		}
+		entry.done = true
+		entry.value = value
+		entry.err = err
+		entry.expiresAt = time.Time{}
+		if err != nil && opts.errorTTL > 0 {
+			entry.expiresAt = time.Now().Add(opts.errorTTL)
+		}
+	}
+	value, ok := entry.value.(T)
+	if !ok && entry.value != nil {
+		return zero, fmt.Errorf("%w: %T", ErrOnceTypeMismatch, entry.value)
+	}
+	return value, entry.err
+}
@@ -0,0 +1,165 @@
+package scene_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type profileKey struct{}
+
+type profile struct {
+	name string
+}
+
+func TestOnce(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	failure := errors.New("lookup failed")
+	t.Run("concurrent callers", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		var calls atomic.Int32
+		load := func() (*profile, error) {
+			calls.Add(1)
+			time.Sleep(time.Millisecond * 10)
+			return &profile{name: "user"}, nil
+		}
+		var wg sync.WaitGroup
+		profiles := make([]*profile, 10)
+		for k := range profiles {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				var err error
+				profiles[k], err = scene.Once(ctx, profileKey{}, load)
+				require.NoError(t, err)
+			}(k)
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), calls.Load())
+		for _, v := range profiles {
+			require.Same(t, profiles[0], v)
+		}
+		// Once keys don't collide with stored values
+		require.Nil(t, ctx.Value(profileKey{}))
+		_, err = scene.Once(ctx, profileKey{}, func() (string, error) {
+			return "", nil
+		})
+		require.ErrorIs(t, err, scene.ErrOnceTypeMismatch)
+
+		ctx.Complete()
+		_, err = scene.Once(ctx, profileKey{}, load)
+		require.ErrorIs(t, err, scene.ErrComplete)
+		require.Equal(t, int32(1), calls.Load())
+	})
+	t.Run("error policies", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		var calls int
+		fail := func() (bool, error) {
+			calls++
+			return false, failure
+		}
+		_, err = scene.Once(ctx, "cached", fail)
+		require.ErrorIs(t, err, failure)
+		_, err = scene.Once(ctx, "cached", fail)
+		require.ErrorIs(t, err, failure)
+		require.Equal(t, 1, calls)
+
+		calls = 0
+		_, err = scene.Once(ctx, "retry", fail, scene.RetryOnError())
+		require.ErrorIs(t, err, failure)
+		allowed, err := scene.Once(ctx, "retry", func() (bool, error) {
+			calls++
+			return true, nil
+		}, scene.RetryOnError())
+		require.NoError(t, err)
+		require.True(t, allowed)
+		require.Equal(t, 2, calls)
+
+		calls = 0
+		_, err = scene.Once(ctx, "ttl", fail, scene.CacheErrorFor(time.Millisecond*10))
+		require.ErrorIs(t, err, failure)
+		_, err = scene.Once(ctx, "ttl", fail, scene.CacheErrorFor(time.Millisecond*10))
+		require.ErrorIs(t, err, failure)
+		require.Equal(t, 1, calls)
+		time.Sleep(time.Millisecond * 20)
+		_, err = scene.Once(ctx, "ttl", fail, scene.CacheErrorFor(time.Millisecond*10))
+		require.ErrorIs(t, err, failure)
+		require.Equal(t, 2, calls)
+	})
+	t.Run("panics are retried", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		require.Panics(t, func() {
+			_, _ = scene.Once(ctx, "panics", func() (int, error) {
+				panic("broken")
+			})
+		})
+		value, err := scene.Once(ctx, "panics", func() (int, error) {
+			return 1, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, value)
+	})
+	t.Run("waiters give up", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		computing, release := make(chan struct{}), make(chan struct{})
+		go func() {
+			_, _ = scene.Once(ctx, "slow", func() (int, error) {
+				close(computing)
+				<-release
+				return 1, nil
+			})
+		}()
+		<-computing
+		waiter, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+		defer cancel()
+		_, err = scene.Once(waiter, "slow", func() (int, error) {
+			t.Fatal("the value is already being computed")
+			return 0, nil
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		// Waiting on the scene itself ends when the scene completes
+		waited := make(chan error)
+		go func() {
+			_, err := scene.Once(ctx, "slow", func() (int, error) {
+				return 0, nil
+			})
+			waited <- err
+		}()
+		ctx.Complete()
+		require.ErrorIs(t, <-waited, scene.ErrComplete)
+		close(release)
+	})
+	t.Run("not a scene", func(t *testing.T) {
+		var calls int
+		for i := 0; i < 2; i++ {
+			_, _ = scene.Once(context.Background(), "key", func() (int, error) {
+				calls++
+				return calls, nil
+			})
+		}
+		require.Equal(t, 2, calls)
+	})
+}
@@ -0,0 +1,188 @@
+package scene
+
+import (
+	ogContext "context"
+	"sync"
+	"time"
+)
+
+// closedChan is returned by Done for pooled scenes that were already reused.
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// pooledContext is the handle for a pooled context.
+// Once the context completes and is reused for another scene, the handle's generation no longer matches,
+// so the handle acts like a completed scene instead of reaching into the new one. Every method passes the generation
+// to the context, which checks it under the same lock the operation runs with.
+type pooledContext struct {
+	c          *context
+	generation uint64
+	// The error the scene completed with, set before the context is reused
+	err error
+}
+
+// asScene gets the context behind a Scene and the generation the Scene is on, false is returned for other contexts.
+// The context may have been reused since if it is pooled, callers check the generation while holding its lock.
+func asScene(ctx ogContext.Context) (*context, uint64, bool) {
+	switch c := ctx.(type) {
+	case *context:
+		return c, c.generation.Load(), true
+	case *pooledContext:
+		return c.c, c.generation, true
+	case *valueView:
+		return asScene(c.Context)
+	}
+	return nil, 0, false
+}
+
+// unwrapScene resolves the context behind any Scene compatible context.
+func unwrapScene(ctx ogContext.Context) (*context, uint64, bool) {
+	return asScene(GetScene(ctx))
+}
+
+// acquire gets a context from the pool, or allocates one if the pool is empty.
+func (factory *Factory) acquire(baseCtx ogContext.Context, id string) *context {
+	c, _ := factory.pool.Get().(*context)
+	if c == nil {
+		c = &context{
+			factory:       factory,
+			contextValues: make(map[any]any, 8),
+			mu:            &sync.RWMutex{},
+		}
+	}
+	c.mu.Lock()
+	if c.contextValues == nil {
+		// The value map is lost when a Defer callback panicked while the previous scene completed
+		c.contextValues = make(map[any]any, 8)
+	}
+	c.Context = baseCtx
+	c.id = id
+	c.isComplete = false
+	c.complete = make(chan struct{})
+	c.self = &pooledContext{c: c, generation: c.generation.Load()}
+	c.mu.Unlock()
+	return c
+}
+
+// recycle resets a completed context and returns it to the pool.
+// values is the cleared value map of the context, it is kept to save on allocations.
+func (factory *Factory) recycle(c *context, values map[any]any) {
+	c.mu.Lock()
+	if handle, ok := c.self.(*pooledContext); ok {
+		handle.err = c.err
+	}
+	// Every handle and timer of the previous scene is invalidated from here on
+	c.generation.Add(1)
+	if c.activeTimer != nil {
+		c.activeTimer.Stop()
+		c.activeTimer = nil
+	}
+	clear(c.onComplete)
+	c.onComplete = c.onComplete[:0]
+	c.Context = nil
+	c.id = ""
+	// Pooled contexts stay complete until they are acquired, so nothing can complete them while they are unused
+	c.isComplete = true
+	c.err = nil
+	c.deadline = 0
+	c.completeBy = 0
+	c.startedAt = time.Time{}
+	c.startedBy = ""
+	c.notice = nil
+	c.lazy = nil
+	c.onces = nil
+	c.inheritFrom = nil
+	c.readOnly = nil
+	c.sealed = false
+	c.writable = nil
+	c.providers = nil
+	c.defaults = nil
+	c.self = nil
+	c.contextValues = values
+	c.mu.Unlock()
+	factory.pool.Put(c)
+}
+
+func (p *pooledContext) Deadline() (deadline time.Time, ok bool) {
+	return p.c.deadlineAt(p.generation)
+}
+
+func (p *pooledContext) Done() <-chan struct{} {
+	return p.c.done(p.generation)
+}
+
+func (p *pooledContext) Err() error {
+	return p.GetLastError()
+}
+
+func (p *pooledContext) Value(key any) any {
+	val, _ := p.c.lookupValue(p.generation, key)
+	return val
+}
+
+func (p *pooledContext) Store(key, value any) {
+	p.c.storeValue(p.generation, key, value)
+}
+
+func (p *pooledContext) TryStore(key, value any) error {
+	_, err := p.c.store(p.generation, key, value)
+	return err
+}
+
+func (p *pooledContext) Lookup(key any) (any, bool) {
+	return p.c.lookupValue(p.generation, key)
+}
+
+func (p *pooledContext) Delete(key any) {
+	p.c.deleteValue(p.generation, key)
+}
+
+func (p *pooledContext) Attach(ctx ogContext.Context) {
+	p.c.attach(p.generation, ctx)
+}
+
+func (p *pooledContext) Complete() {
+	p.CompleteWithError(ErrComplete)
+}
+
+func (p *pooledContext) Defer(fn CompleteFunc) {
+	p.c.addDefer(p.generation, fn)
+}
+
+func (p *pooledContext) Spawn(completeBy time.Time) (Context, error) {
+	return p.c.spawn(p.generation, completeBy)
+}
+
+func (p *pooledContext) CompleteWithError(err error) {
+	p.c.completeWithError(p.generation, err)
+}
+
+func (p *pooledContext) GetLastError() error {
+	if err, ok := p.c.lastError(p.generation); ok {
+		return err
+	}
+	return p.err
+}
+
+func (p *pooledContext) GetBaseCtx() ogContext.Context {
+	return p.c.baseCtx(p.generation)
+}
+
+func (p *pooledContext) Extend(until time.Time) {
+	p.c.extend(p.generation, until)
+}
+
+func (p *pooledContext) Seal(writable ...any) {
+	p.c.seal(p.generation, writable)
+}
+
+func (p *pooledContext) With(key, value any) Context {
+	return newValueView(p, key, value)
+}
+
+func (p *pooledContext) Scoped(fn func(ctx Context)) {
+	runScoped(p, fn)
+}
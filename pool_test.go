@@ -0,0 +1,227 @@
+package scene_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type poolValueProvider struct {
+	scene.BaseProvider
+}
+
+func (p poolValueProvider) OnNewContext(ctx scene.Context) {
+	ctx.Store("provider", scene.GetRequestID(ctx))
+}
+
+func newPooledFactory(t testing.TB, maxTTL time.Duration) *scene.Factory {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            maxTTL,
+		LogOutput:         logger,
+		PoolContexts:      true,
+	}, poolValueProvider{})
+	return factory
+}
+
+func TestFactory_PoolContexts(t *testing.T) {
+	factory := newPooledFactory(t, scene.NoTTL)
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	factory.StoreDefault("default", "val")
+	first, err := factory.NewCtx()
+	require.NoError(t, err)
+	firstID := scene.GetRequestID(first)
+	require.Equal(t, firstID, first.Value("provider"))
+	require.Equal(t, first, scene.GetScene(first))
+	var deferred scene.Context
+	first.Defer(func(ctx scene.Context, completeErr error) {
+		deferred = ctx
+		require.Equal(t, "val", ctx.Value("default"))
+	})
+	first.Store("stored", "first")
+	first.Complete()
+	require.Equal(t, first, deferred)
+
+	// Regardless of whether the context was reused, the old handle acts like a completed scene
+	for i := 0; i < 10; i++ {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		require.NotEqual(t, firstID, scene.GetRequestID(ctx))
+		require.Equal(t, scene.GetRequestID(ctx), ctx.Value("provider"))
+		require.Nil(t, ctx.Value("stored"))
+		require.Equal(t, "val", ctx.Value("default"))
+		require.NoError(t, ctx.Err())
+
+		first.Store("stored", "stale")
+		first.Complete()
+		first.Defer(func(ctx scene.Context, completeErr error) {
+			t.Fatal("stale handles can't register callbacks")
+		})
+		_, err = first.Spawn(scene.RunForever)
+		require.ErrorIs(t, err, scene.ErrShutdownInProgress)
+		<-first.Done()
+		require.Error(t, first.Err())
+		require.Nil(t, first.Value("stored"))
+
+		require.Nil(t, ctx.Value("stored"))
+		require.NoError(t, ctx.Err())
+		spawned, err := ctx.Spawn(scene.RunForever)
+		require.NoError(t, err)
+		require.Equal(t, scene.GetRequestID(spawned), spawned.Value("provider"))
+		spawned.Complete()
+		ctx.Complete()
+	}
+	require.Equal(t, 0, factory.OpenContexts())
+}
+
+func TestFactory_PoolContextsTimeout(t *testing.T) {
+	factory := newPooledFactory(t, time.Millisecond*10)
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	// Deadline timers of a completed scene must not complete the scene its context was reused for
+	for i := 0; i < 5; i++ {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		ctx.Complete()
+	}
+	ctx, err := factory.WrapWithTTL(context.Background(), scene.NoTTL)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 30)
+	require.NoError(t, ctx.Err())
+	ctx.Complete()
+
+	ctx, err = factory.NewCtx()
+	require.NoError(t, err)
+	<-ctx.Done()
+	require.ErrorIs(t, ctx.Err(), scene.ErrTimeout)
+}
+
+func TestFactory_PoolContextsConcurrently(t *testing.T) {
+	factory := newPooledFactory(t, time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				ctx, err := factory.NewCtx()
+				require.NoError(t, err)
+				ctx.Store("key", j)
+				require.Equal(t, j, ctx.Value("key"))
+				ctx.Complete()
+			}
+		}()
+	}
+	wg.Wait()
+	require.True(t, factory.Shutdown(time.Second))
+}
+
+func TestFactory_PoolContextsPanickingDefer(t *testing.T) {
+	factory := newPooledFactory(t, scene.NoTTL)
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	for i := 0; i < 10; i++ {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		ctx.Defer(func(ctx scene.Context, completeErr error) {
+			panic("defer failed")
+		})
+		require.Panics(t, ctx.Complete)
+		// The panicked context may be handed out again and must still be usable
+		next, err := factory.NewCtx()
+		require.NoError(t, err)
+		next.Store("key", i)
+		require.Equal(t, i, next.Value("key"))
+		next.Complete()
+	}
+}
+
+func TestFactory_PoolContextsStaleHandles(t *testing.T) {
+	factory := newPooledFactory(t, scene.NoTTL)
+	stale, err := factory.NewCtx()
+	require.NoError(t, err)
+	stale.Complete()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var leaked atomic.Bool
+	// A stale handle racing the reuse of its context must never write into the new scene
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				stale.Store("stale", true)
+				_ = stale.TryStore("stale", true)
+				stale.Delete("provider")
+				stale.Seal()
+				stale.Defer(func(ctx scene.Context, completeErr error) {
+					leaked.Store(true)
+				})
+				stale.Range(func(key, value any) bool {
+					return true
+				})
+				_ = stale.Value("provider")
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		require.Equal(t, scene.GetRequestID(ctx), ctx.Value("provider"))
+		require.Nil(t, ctx.Value("stale"))
+		require.NoError(t, ctx.TryStore("key", i))
+		ctx.Complete()
+	}
+	close(stop)
+	wg.Wait()
+	require.False(t, leaked.Load())
+	require.True(t, factory.Shutdown(time.Second))
+}
+
+func BenchmarkFactory_PoolContexts(b *testing.B) {
+	for _, pooled := range []bool{false, true} {
+		name := "allocate"
+		if pooled {
+			name = "pooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			factory, _ := scene.NewSceneFactory(scene.Config{
+				FactoryIdentifier: "Bench",
+				MaxTTL:            scene.NoTTL,
+				LogOutput:         zerolog.Nop(),
+				PoolContexts:      pooled,
+			}, scene.BaseProvider{})
+			b.Cleanup(func() {
+				factory.Shutdown(time.Second)
+			})
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					ctx, _ := factory.NewCtx()
+					ctx.Store("key", "value")
+					_ = ctx.Value("key")
+					ctx.Complete()
+				}
+			})
+		})
+	}
+}
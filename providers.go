@@ -0,0 +1,268 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+var ErrProviderNotFound = errors.New("provider is not mounted on this factory")
+var ErrProviderAlreadyMounted = errors.New("provider is already mounted on this factory")
+var ErrMountInProgress = errors.New("contexts can not be created while a provider mounts")
+
+// mountedProvider is a provider instance along with the number of open contexts that were created with it.
+type mountedProvider struct {
+	Provider
+	// The factory the provider was mounted on, contexts of child factories also pin their ancestors' providers
+	owner *Factory
+	// Open contexts created while this instance was mounted
+	refs atomic.Int64
+	// Set once the instance was swapped out or unregistered, it is unmounted when refs reaches 0
+	retired   atomic.Bool
+	unmounted atomic.Bool
+}
+
+func mountProviders(owner *Factory, providers []Provider) []*mountedProvider {
+	mounted := make([]*mountedProvider, 0, len(providers))
+	for _, v := range providers {
+		if v != nil {
+			mounted = append(mounted, &mountedProvider{Provider: v, owner: owner})
+		}
+	}
+	return mounted
+}
+
+// release drops a context's reference, unmounting a retired instance once its last context completes.
+func (m *mountedProvider) release() {
+	if m.refs.Add(-1) == 0 && m.retired.Load() {
+		// The scene that completes last shouldn't wait on the unmount, Shutdown waits for it instead
+		m.owner.unmountRetiredAsync(m)
+	}
+}
+
+// retirement is the unmount of a retired instance running in the background.
+type retirement struct {
+	done   chan struct{}
+	report ProviderReport
+	ran    bool
+}
+
+// unmountRetiredAsync unmounts a retired instance on its own goroutine.
+func (factory *Factory) unmountRetiredAsync(m *mountedProvider) {
+	r := &retirement{done: make(chan struct{})}
+	factory.retiringLock.Lock()
+	// Retirements that already finished were logged, only the ones Shutdown may still need to wait on are kept
+	pending := factory.retiring[:0]
+	for _, v := range factory.retiring {
+		select {
+		case <-v.done:
+		default:
+			pending = append(pending, v)
+		}
+	}
+	factory.retiring = append(pending, r)
+	factory.retiringLock.Unlock()
+	go func() {
+		defer close(r.done)
+		r.report, r.ran = factory.unmountRetired(m)
+		if r.report.Err != nil {
+			factory.factoryLogger.Error().Str("provider", r.report.Provider).Err(r.report.Err).Send()
+		}
+	}()
+}
+
+// waitForRetirements waits for retired instances that are unmounting in the background,
+// returning the reports of the ones that ran since the last wait.
+func (factory *Factory) waitForRetirements() []ProviderReport {
+	factory.retiringLock.Lock()
+	retiring := factory.retiring
+	factory.retiring = nil
+	factory.retiringLock.Unlock()
+	var reports []ProviderReport
+	for _, r := range retiring {
+		// Bounded by the unmount timeout of the instance
+		<-r.done
+		if r.ran {
+			reports = append(reports, r.report)
+		}
+	}
+	return reports
+}
+
+// unmountRetired unmounts a removed instance exactly once, false is returned if it was already unmounted.
+func (factory *Factory) unmountRetired(m *mountedProvider) (ProviderReport, bool) {
+	if !m.unmounted.CompareAndSwap(false, true) {
+		return ProviderReport{}, false
+	}
+	factory.defaultsLock.Lock()
+	for k, v := range factory.retired {
+		if v == m {
+			factory.retired = append(factory.retired[:k:k], factory.retired[k+1:]...)
+			break
+		}
+	}
+	factory.defaultsLock.Unlock()
+	return factory.unmountProvider(m.Provider, DefaultUnmountTimeout), true
+}
+
+// sameProvider compares two providers by identity without panicking on uncomparable types.
+func sameProvider(a, b Provider) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	aType := reflect.TypeOf(a)
+	if aType != reflect.TypeOf(b) || !aType.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// mountValuer is handed to OnFactoryMount while defaultsLock is already held.
+type mountValuer struct {
+	factory *Factory
+}
+
+func (m mountValuer) StoreDefault(key, value any) {
+	m.factory.storeDefault(key, value)
+}
+
+func (m mountValuer) GetDefault(key any) any {
+	return m.factory.GetDefault(key)
+}
+
+func (m mountValuer) NewCtx() (Context, error) {
+	return nil, ErrMountInProgress
+}
+
+// providers gets the current list of mounted providers, the list must not be modified.
+func (factory *Factory) providers() []*mountedProvider {
+	return *factory.injectors.Load()
+}
+
+// indexOf finds a mounted provider, -1 is returned if it is not mounted.
+func indexOf(providers []*mountedProvider, provider Provider) int {
+	for k, v := range providers {
+		if sameProvider(v.Provider, provider) {
+			return k
+		}
+	}
+	return -1
+}
+
+// Register mounts a provider on a running factory, e.g. for plugins loaded after startup.
+// OnFactoryMount runs before the provider is published, so every scene created with the provider sees its defaults.
+// Scenes that are already open are not affected.
+func (factory *Factory) Register(provider Provider) error {
+	if provider == nil {
+		return ErrProviderNotFound
+	}
+	factory.defaultsLock.Lock()
+	defer factory.defaultsLock.Unlock()
+	// Checked under the lock so a provider can't be published after shutdown collected the providers to unmount
+	if factory.closed.Load() {
+		return ErrShutdownInProgress
+	}
+	current := factory.providers()
+	if indexOf(current, provider) != -1 {
+		return ErrProviderAlreadyMounted
+	}
+	provider.OnFactoryMount(mountValuer{factory: factory})
+	// Copy on write, contexts hold onto the list they were created with
+	providers := make([]*mountedProvider, len(current), len(current)+1)
+	copy(providers, current)
+	providers = append(providers, &mountedProvider{Provider: provider, owner: factory})
+	factory.injectors.Store(&providers)
+	return nil
+}
+
+// Unregister removes a provider from a running factory, new scenes no longer use it.
+// OnFactoryUnmount runs straight away if no open scene was created with the provider and its error is returned,
+// otherwise it runs in the background once the last of those scenes completes, Shutdown waits for it.
+func (factory *Factory) Unregister(provider Provider) error {
+	factory.defaultsLock.Lock()
+	if factory.closed.Load() {
+		factory.defaultsLock.Unlock()
+		return ErrShutdownInProgress
+	}
+	current := factory.providers()
+	index := indexOf(current, provider)
+	if index == -1 {
+		factory.defaultsLock.Unlock()
+		return ErrProviderNotFound
+	}
+	providers := make([]*mountedProvider, 0, len(current)-1)
+	providers = append(providers, current[:index]...)
+	providers = append(providers, current[index+1:]...)
+	factory.injectors.Store(&providers)
+	factory.markRetired(current[index])
+	factory.defaultsLock.Unlock()
+	return factory.retire(current[index])
+}
+
+// Reload re-runs OnFactoryMount on a mounted provider so it can refresh the defaults it stores
+// (e.g. after credentials were rotated).
+// The defaults are replaced atomically, new scenes see the new values while open scenes keep the values they were
+// created with.
+func (factory *Factory) Reload(provider Provider) error {
+	factory.defaultsLock.Lock()
+	defer factory.defaultsLock.Unlock()
+	if factory.closed.Load() {
+		return ErrShutdownInProgress
+	}
+	current := factory.providers()
+	index := indexOf(current, provider)
+	if index == -1 {
+		return ErrProviderNotFound
+	}
+	current[index].OnFactoryMount(mountValuer{factory: factory})
+	return nil
+}
+
+// Swap replaces a mounted provider with a new instance in the same position.
+// The replacement is mounted atomically, so new scenes use it and see its defaults while open scenes keep using the
+// old instance. The old instance is unmounted in the background once the last scene created with it completes.
+func (factory *Factory) Swap(old, replacement Provider) error {
+	if replacement == nil {
+		return ErrProviderNotFound
+	}
+	factory.defaultsLock.Lock()
+	if factory.closed.Load() {
+		factory.defaultsLock.Unlock()
+		return ErrShutdownInProgress
+	}
+	current := factory.providers()
+	index := indexOf(current, old)
+	if index == -1 {
+		factory.defaultsLock.Unlock()
+		return ErrProviderNotFound
+	}
+	replacement.OnFactoryMount(mountValuer{factory: factory})
+	providers := make([]*mountedProvider, len(current))
+	copy(providers, current)
+	providers[index] = &mountedProvider{Provider: replacement, owner: factory}
+	factory.injectors.Store(&providers)
+	factory.markRetired(current[index])
+	factory.defaultsLock.Unlock()
+	if err := factory.retire(current[index]); err != nil {
+		factory.factoryLogger.Error().Str("provider", fmt.Sprintf("%T", old)).Err(err).Send()
+	}
+	return nil
+}
+
+// markRetired records a provider that was removed from the list so it is unmounted after its last scene completes.
+// defaultsLock must be held.
+func (factory *Factory) markRetired(m *mountedProvider) {
+	factory.retired = append(factory.retired, m)
+	m.retired.Store(true)
+}
+
+// retire unmounts a provider marked by markRetired straight away if no open scene uses it.
+// defaultsLock must not be held, unmounting takes it to drop the provider from the retired list.
+func (factory *Factory) retire(m *mountedProvider) error {
+	if m.refs.Load() == 0 {
+		report, _ := factory.unmountRetired(m)
+		return report.Err
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package scene_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type credentialsKey struct{}
+
+type credentialsProvider struct {
+	scene.BaseProvider
+	password  string
+	mounts    int
+	unmounted chan struct{}
+}
+
+func newCredentialsProvider(password string) *credentialsProvider {
+	return &credentialsProvider{password: password, unmounted: make(chan struct{})}
+}
+
+func (c *credentialsProvider) OnFactoryMount(valuer scene.FactoryDefaultValuer) {
+	c.mounts++
+	valuer.StoreDefault(credentialsKey{}, c.password)
+}
+
+func (c *credentialsProvider) OnFactoryUnmount(valuer scene.FactoryDefaultValuer) error {
+	close(c.unmounted)
+	return nil
+}
+
+func (c *credentialsProvider) isUnmounted() bool {
+	select {
+	case <-c.unmounted:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFactory_Reload(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	provider := newCredentialsProvider("first")
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, provider)
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	provider.password = "second"
+	require.NoError(t, factory.Reload(provider))
+	require.Equal(t, 2, provider.mounts)
+	require.Equal(t, "first", ctx.Value(credentialsKey{}))
+	ctx.Complete()
+	ctx, err = factory.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, "second", ctx.Value(credentialsKey{}))
+	ctx.Complete()
+	require.False(t, provider.isUnmounted())
+
+	require.ErrorIs(t, factory.Reload(newCredentialsProvider("other")), scene.ErrProviderNotFound)
+	require.True(t, factory.Shutdown(time.Second))
+	require.True(t, provider.isUnmounted())
+	require.ErrorIs(t, factory.Reload(provider), scene.ErrShutdownInProgress)
+}
+
+func TestFactory_Swap(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	old := newCredentialsProvider("old")
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, scene.BaseProvider{}, old)
+	inFlight, err := factory.NewCtx()
+	require.NoError(t, err)
+	spawned, err := inFlight.Spawn(scene.RunForever)
+	require.NoError(t, err)
+
+	replacement := newCredentialsProvider("new")
+	require.NoError(t, factory.Swap(old, replacement))
+	require.ErrorIs(t, factory.Swap(old, replacement), scene.ErrProviderNotFound)
+	require.Equal(t, "old", inFlight.Value(credentialsKey{}))
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, "new", ctx.Value(credentialsKey{}))
+	ctx.Complete()
+
+	// The old instance stays mounted until every scene created with it completes
+	inFlight.Complete()
+	require.False(t, old.isUnmounted())
+	spawned.Complete()
+	// The unmount runs in the background, so completing the last scene doesn't wait on it
+	require.Eventually(t, old.isUnmounted, time.Second, time.Millisecond)
+	require.False(t, replacement.isUnmounted())
+
+	// Swapping an instance without open scenes unmounts it straight away
+	third := newCredentialsProvider("third")
+	require.NoError(t, factory.Swap(replacement, third))
+	require.True(t, replacement.isUnmounted())
+
+	require.True(t, factory.Shutdown(time.Second))
+	require.True(t, third.isUnmounted())
+}
+
+// slowUnmountProvider takes a while to unmount, to check that completing a scene doesn't wait on it.
+type slowUnmountProvider struct {
+	scene.BaseProvider
+	unmounted chan struct{}
+}
+
+func (s slowUnmountProvider) OnFactoryUnmount(valuer scene.FactoryDefaultValuer) error {
+	time.Sleep(time.Millisecond * 100)
+	close(s.unmounted)
+	return nil
+}
+
+func TestFactory_RetiredUnmountsInBackground(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	old := slowUnmountProvider{unmounted: make(chan struct{})}
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, old)
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	require.NoError(t, factory.Unregister(old))
+	started := time.Now()
+	ctx.Complete()
+	require.Less(t, time.Since(started), time.Millisecond*50)
+
+	// Shutdown waits for the unmount and reports it
+	report := factory.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Second})
+	require.True(t, report.Clean())
+	require.Len(t, report.Providers, 1)
+	require.Equal(t, "scene_test.slowUnmountProvider", report.Providers[0].Provider)
+	<-old.unmounted
+}
+
+func TestFactory_SwapUnmountedOnShutdown(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	old := newCredentialsProvider("old")
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, old)
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	replacement := newCredentialsProvider("new")
+	require.NoError(t, factory.Swap(old, replacement))
+	report := factory.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Millisecond * 10})
+	require.False(t, report.Drained)
+	require.Len(t, report.Providers, 2)
+	require.True(t, old.isUnmounted())
+	require.True(t, replacement.isUnmounted())
+}
+
+func TestFactory_Register(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	before, err := factory.NewCtx()
+	require.NoError(t, err)
+	plugin := newCredentialsProvider("plugin")
+	require.NoError(t, factory.Register(plugin))
+	require.ErrorIs(t, factory.Register(plugin), scene.ErrProviderAlreadyMounted)
+	require.Equal(t, 1, plugin.mounts)
+	require.Nil(t, before.Value(credentialsKey{}))
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	require.Equal(t, "plugin", ctx.Value(credentialsKey{}))
+
+	// The plugin stays mounted for the scene that was created with it
+	require.NoError(t, factory.Unregister(plugin))
+	require.ErrorIs(t, factory.Unregister(plugin), scene.ErrProviderNotFound)
+	require.False(t, plugin.isUnmounted())
+	before.Complete()
+	require.False(t, plugin.isUnmounted())
+	ctx.Complete()
+	require.Eventually(t, plugin.isUnmounted, time.Second, time.Millisecond)
+
+	other := newCredentialsProvider("other")
+	require.NoError(t, factory.Register(other))
+	require.NoError(t, factory.Unregister(other))
+	require.True(t, other.isUnmounted())
+
+	require.True(t, factory.Shutdown(time.Second))
+	require.ErrorIs(t, factory.Register(newCredentialsProvider("late")), scene.ErrShutdownInProgress)
+	require.ErrorIs(t, factory.Unregister(plugin), scene.ErrShutdownInProgress)
+}
+
+func TestFactory_RegisterConcurrently(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	var wg sync.WaitGroup
+	providers := make([]*credentialsProvider, 20)
+	for k := range providers {
+		providers[k] = newCredentialsProvider("plugin")
+		wg.Add(2)
+		go func(provider *credentialsProvider) {
+			defer wg.Done()
+			require.NoError(t, factory.Register(provider))
+		}(providers[k])
+		go func() {
+			defer wg.Done()
+			ctx, err := factory.NewCtx()
+			require.NoError(t, err)
+			spawned, err := ctx.Spawn(scene.RunForever)
+			require.NoError(t, err)
+			spawned.Complete()
+			ctx.Complete()
+		}()
+	}
+	wg.Wait()
+	require.True(t, factory.Shutdown(time.Second))
+	for _, provider := range providers {
+		require.True(t, provider.isUnmounted())
+	}
+}
@@ -0,0 +1,91 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrReadOnlyKey = errors.New("key is read-only")
+var ErrSealed = errors.New("scene is sealed")
+
+// isReadOnlyKey reports whether key is set by the scene itself, these can never be stored over.
+func isReadOnlyKey(key any) bool {
+	switch key.(type) {
+	case RequestIDKey, ContextRef, LineageKey:
+		return true
+	}
+	return false
+}
+
+// checkWritable returns why key can't be stored on the context, the lock must be held.
+func (c *context) checkWritable(key any) error {
+	if _, found := c.readOnly[key]; found || isReadOnlyKey(key) {
+		return fmt.Errorf("%w: %s", ErrReadOnlyKey, formatKey(key))
+	}
+	if _, found := c.writable[key]; c.sealed && !found {
+		return fmt.Errorf("%w: %s is not writable", ErrSealed, formatKey(key))
+	}
+	return nil
+}
+
+// canStore is checkWritable for callers that don't hold the lock, ErrComplete is returned once the context was reused.
+func (c *context) canStore(generation uint64, key any) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.current(generation) {
+		return ErrComplete
+	}
+	return c.checkWritable(key)
+}
+
+// rejectStore handles a Store to a protected key, it panics in DebugMode so the offending caller is found early.
+func (c *context) rejectStore(requestID string, err error) {
+	if c.factory.config.DebugMode {
+		panic(err)
+	}
+	c.factory.factoryLogger.Error().Str("requestID", requestID).Err(err).Msg("store rejected")
+}
+
+// StoreReadOnly stores value for key on the scene behind ctx and makes key read-only for the rest of the scene,
+// further stores are rejected with ErrReadOnlyKey. Providers use this in OnNewContext for keys other code must not
+// clobber. The request ID, scene reference and lineage keys are always read-only.
+func StoreReadOnly(ctx Context, key, value any) error {
+	c, generation, ok := unwrapScene(ctx)
+	if !ok {
+		return ErrComplete
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.live(generation) {
+		return ErrComplete
+	}
+	if err := c.checkWritable(key); err != nil {
+		return err
+	}
+	if c.readOnly == nil {
+		c.readOnly = make(map[any]struct{})
+	}
+	c.contextValues[key] = value
+	c.readOnly[key] = struct{}{}
+	return nil
+}
+
+// Seal locks the scene's values once setup is done, e.g. after the providers and on request hooks ran.
+// Afterward only the writable keys can be stored, other stores are rejected with ErrSealed.
+// Sealing an already sealed scene does nothing, so the writable keys can't be widened later.
+func (c *context) Seal(writable ...any) {
+	c.seal(c.generation.Load(), writable)
+}
+
+func (c *context) seal(generation uint64, writable []any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sealed || !c.live(generation) {
+		return
+	}
+	c.sealed = true
+	c.writable = make(map[any]struct{}, len(writable))
+	for _, key := range writable {
+		c.writable[key] = struct{}{}
+	}
+}
@@ -0,0 +1,124 @@
+package scene_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type tenantIDKey struct{}
+
+type tenantProvider struct {
+	scene.BaseProvider
+}
+
+func (p tenantProvider) OnNewContext(ctx scene.Context) {
+	_ = scene.StoreReadOnly(ctx, tenantIDKey{}, "acme")
+}
+
+func TestStoreReadOnly(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, tenantProvider{})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	id := scene.GetRequestID(ctx)
+
+	ctx.Store(scene.RequestIDKey{}, "forged")
+	ctx.Store(scene.ContextRef{}, nil)
+	ctx.Store(tenantIDKey{}, "other")
+	require.Equal(t, id, scene.GetRequestID(ctx))
+	require.Equal(t, ctx, ctx.Value(scene.ContextRef{}))
+	require.Equal(t, "acme", ctx.Value(tenantIDKey{}))
+	require.Contains(t, buf.String(), "store rejected")
+	require.ErrorIs(t, scene.StoreReadOnly(ctx, tenantIDKey{}, "other"), scene.ErrReadOnlyKey)
+	require.ErrorIs(t, scene.StoreReadOnly(ctx, scene.RequestIDKey{}, "forged"), scene.ErrReadOnlyKey)
+
+	// Scoped views can't override protected keys either
+	ctx.Scoped(func(scoped scene.Context) {
+		scoped.Store(tenantIDKey{}, "other")
+		require.Equal(t, "acme", scoped.Value(tenantIDKey{}))
+	})
+	// Neither can value views
+	require.Equal(t, id, scene.GetRequestID(ctx.With(scene.RequestIDKey{}, "forged")))
+	require.Equal(t, "acme", ctx.With(tenantIDKey{}, "other").Value(tenantIDKey{}))
+	require.Equal(t, "acme", ctx.With("other", true).With(tenantIDKey{}, "other").Value(tenantIDKey{}))
+	ctx.Complete()
+	require.ErrorIs(t, scene.StoreReadOnly(ctx, "late", true), scene.ErrComplete)
+}
+
+func TestContext_Seal(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+		PoolContexts:      true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	ctx.Store("user", "alice")
+	ctx.Seal("status")
+	ctx.Seal("user")
+
+	ctx.Store("status", 200)
+	ctx.Store("user", "mallory")
+	require.Equal(t, 200, ctx.Value("status"))
+	require.Equal(t, "alice", ctx.Value("user"))
+	require.ErrorIs(t, scene.StoreReadOnly(ctx, "user", "mallory"), scene.ErrSealed)
+	require.Equal(t, "alice", ctx.With("user", "mallory").Value("user"))
+	require.Equal(t, 201, ctx.With("status", 201).Value("status"))
+	ctx.Complete()
+
+	// Reused contexts start unsealed
+	ctx, err = factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	ctx.Store("user", "bob")
+	require.Equal(t, "bob", ctx.Value("user"))
+}
+
+func TestContext_SealDebugMode(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+		DebugMode:         true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	require.Panics(t, func() {
+		ctx.Store(scene.RequestIDKey{}, "forged")
+	})
+	require.Panics(t, func() {
+		ctx.With(scene.RequestIDKey{}, "forged")
+	})
+	ctx.Seal()
+	require.Panics(t, func() {
+		ctx.Store("user", "mallory")
+	})
+	require.Panics(t, func() {
+		ctx.With("user", "mallory")
+	})
+}
@@ -0,0 +1,161 @@
+package scene
+
+import (
+	ogContext "context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultServerShutdownTimeout is used when ServerConfig.ShutdownTimeout is not set.
+const DefaultServerShutdownTimeout = 30 * time.Second
+
+// ServerConfig configures a Server.
+type ServerConfig struct {
+	// Signals that trigger a graceful shutdown, defaults to SIGINT and SIGTERM.
+	Signals []os.Signal
+	// ShutdownTimeout is the total time in-flight requests and scenes have to finish.
+	ShutdownTimeout time.Duration
+	// UnmountTimeout is the deadline for each provider to unmount, see ShutdownConfig.
+	UnmountTimeout time.Duration
+}
+
+// ShutdownSummary describes how a Server shut down.
+type ShutdownSummary struct {
+	// Reason is the signal that triggered the shutdown, "requested" when Shutdown was called or "server error".
+	Reason    string
+	StartedAt time.Time
+	Duration  time.Duration
+	// OpenContexts is the number of scenes that were open when the shutdown started.
+	OpenContexts int
+	// HTTPErr is the error from http.Server.Shutdown, generally a deadline error when requests did not finish in time.
+	HTTPErr error
+	// FactoryErr is set to ErrShutdownInProgress if the factory had already been shut down elsewhere.
+	FactoryErr error
+	// Factory is the report of the factory's phased shutdown.
+	Factory ShutdownReport
+	// Clean is true if every request and scene finished before the timeout and every provider unmounted.
+	Clean bool
+}
+
+// Server owns a http.Server and the Factory backing its middleware, shutting both down in the correct order.
+//
+//	On a signal (or Shutdown) the factory stops accepting new scenes, so the middleware answers any new request with a
+//	503 and a Retry-After header. The http.Server then stops listening and waits for in-flight requests, after which
+//	the factory waits for any remaining scenes and unmounts its providers.
+type Server struct {
+	httpServer *http.Server
+	factory    *Factory
+	config     ServerConfig
+	stop       chan string
+	stopOnce   *sync.Once
+	finished   chan struct{}
+	summary    ShutdownSummary
+}
+
+// NewServer creates a new server, the http.Server's handler should be built from an HTTPMiddleware on factory.
+func NewServer(httpServer *http.Server, factory *Factory, config ServerConfig) *Server {
+	if len(config.Signals) == 0 {
+		config.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = DefaultServerShutdownTimeout
+	}
+	return &Server{
+		httpServer: httpServer,
+		factory:    factory,
+		config:     config,
+		stop:       make(chan string, 1),
+		stopOnce:   &sync.Once{},
+		finished:   make(chan struct{}),
+	}
+}
+
+// ListenAndServe listens on the http.Server's address and blocks until the server has fully shut down.
+func (s *Server) ListenAndServe() (ShutdownSummary, error) {
+	addr := s.httpServer.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return ShutdownSummary{}, err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener and blocks until the server has fully shut down.
+// The returned error is only set if the http.Server failed, a graceful shutdown returns nil.
+func (s *Server) Serve(listener net.Listener) (ShutdownSummary, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, s.config.Signals...)
+	defer signal.Stop(signals)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.httpServer.Serve(listener)
+	}()
+	var reason string
+	var err error
+	select {
+	case sig := <-signals:
+		reason = sig.String()
+	case reason = <-s.stop:
+	case err = <-serveErr:
+		reason = "server error"
+	}
+	s.summary = s.shutdown(reason)
+	if err == nil {
+		// Serve returns http.ErrServerClosed once Shutdown is called
+		<-serveErr
+	}
+	close(s.finished)
+	return s.summary, err
+}
+
+// Shutdown triggers a graceful shutdown of a running server and waits for it to finish.
+// This must only be called while Serve or ListenAndServe are running.
+func (s *Server) Shutdown() ShutdownSummary {
+	s.stopOnce.Do(func() {
+		s.stop <- "requested"
+	})
+	<-s.finished
+	return s.summary
+}
+
+func (s *Server) shutdown(reason string) ShutdownSummary {
+	summary := ShutdownSummary{
+		Reason:       reason,
+		StartedAt:    time.Now(),
+		OpenContexts: s.factory.OpenContexts(),
+	}
+	// Reject new scenes first so requests on open connections are told to retry while the server drains
+	accepting := s.factory.stopAccepting()
+	ctx, cancel := ogContext.WithTimeout(ogContext.Background(), s.config.ShutdownTimeout)
+	summary.HTTPErr = s.httpServer.Shutdown(ctx)
+	cancel()
+	if accepting {
+		summary.Factory = s.factory.shutdownPhases(ShutdownConfig{
+			DrainTimeout:   s.config.ShutdownTimeout - time.Since(summary.StartedAt),
+			ForceComplete:  s.factory.config.ForceCompleteOnShutdown,
+			UnmountTimeout: s.config.UnmountTimeout,
+		}, summary.StartedAt)
+		summary.Clean = summary.Factory.Clean() && summary.HTTPErr == nil
+	} else {
+		summary.FactoryErr = ErrShutdownInProgress
+	}
+	summary.Duration = time.Since(summary.StartedAt)
+	s.factory.factoryLogger.Info().
+		Str("factoryIdentifier", s.factory.factoryIdentifier).
+		Str("reason", summary.Reason).
+		Dur("duration", summary.Duration).
+		Int("openContexts", summary.OpenContexts).
+		AnErr("httpErr", summary.HTTPErr).
+		AnErr("factoryErr", summary.FactoryErr).
+		Bool("clean", summary.Clean).
+		Msg("server shutdown complete")
+	return summary
+}
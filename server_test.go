@@ -0,0 +1,137 @@
+package scene_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/scene/encoders"
+	"github.com/weisbartb/tsbuffer"
+)
+
+func newTestServer(t *testing.T, handler http.Handler) (*scene.Factory, *scene.HTTPMiddleware, net.Listener) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second * 5,
+		LogOutput:         logger,
+	})
+	middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+		return encoders.NewJSONEncoder(request.Header, testWrapper{})
+	}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {}, scene.WithRetryAfter(time.Second*3))
+	require.NoError(t, err)
+	middleware.Next(handler)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return factory, middleware, listener
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	factory, middleware, listener := newTestServer(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_ = scene.GetEncoder(scene.GetScene(r.Context())).Encode("done")
+	}})
+	server := scene.NewServer(&http.Server{Handler: middleware}, factory, scene.ServerConfig{ShutdownTimeout: time.Second * 2})
+	type result struct {
+		summary scene.ShutdownSummary
+		err     error
+	}
+	served := make(chan result)
+	go func() {
+		summary, err := server.Serve(listener)
+		served <- result{summary, err}
+	}()
+	responses := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		responses <- string(body)
+	}()
+	<-started
+	shutdown := make(chan scene.ShutdownSummary)
+	go func() {
+		shutdown <- server.Shutdown()
+	}()
+	// New scenes are rejected while the in-flight request drains
+	require.Eventually(t, func() bool {
+		select {
+		case <-factory.Done():
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	recorder := httptest.NewRecorder()
+	middleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	require.Equal(t, "3", recorder.Header().Get("Retry-After"))
+	require.Contains(t, recorder.Body.String(), `"statusCode":503`)
+
+	close(release)
+	require.Contains(t, <-responses, `"data":"done"`)
+	res := <-served
+	require.NoError(t, res.err)
+	summary := <-shutdown
+	require.Equal(t, res.summary, summary)
+	require.Equal(t, "requested", summary.Reason)
+	require.Equal(t, 1, summary.OpenContexts)
+	require.NoError(t, summary.HTTPErr)
+	require.NoError(t, summary.FactoryErr)
+	require.True(t, summary.Clean)
+	require.Equal(t, 0, factory.OpenContexts())
+}
+
+func TestServer_Signal(t *testing.T) {
+	factory, middleware, listener := newTestServer(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {}})
+	server := scene.NewServer(&http.Server{Handler: middleware}, factory, scene.ServerConfig{Signals: []os.Signal{os.Interrupt}})
+	served := make(chan scene.ShutdownSummary)
+	go func() {
+		summary, err := server.Serve(listener)
+		require.NoError(t, err)
+		served <- summary
+	}()
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusNoContent
+	}, time.Second, time.Millisecond*10)
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skip("sending signals is not supported on this platform")
+	}
+	summary := <-served
+	require.True(t, strings.EqualFold(os.Interrupt.String(), summary.Reason))
+	require.True(t, summary.Clean)
+}
+
+func TestServer_FactoryAlreadyShutdown(t *testing.T) {
+	factory, middleware, listener := newTestServer(t, testHandler{call: func(writer http.ResponseWriter, r *http.Request) {}})
+	server := scene.NewServer(&http.Server{Handler: middleware}, factory, scene.ServerConfig{})
+	require.True(t, factory.Shutdown(time.Second))
+	go func() {
+		_ = server.Shutdown()
+	}()
+	summary, err := server.Serve(listener)
+	require.NoError(t, err)
+	require.ErrorIs(t, summary.FactoryErr, scene.ErrShutdownInProgress)
+	require.False(t, summary.Clean)
+}
@@ -0,0 +1,340 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrDrainTimeout = errors.New("contexts did not complete before the drain deadline")
+var ErrUnmountTimeout = errors.New("provider did not unmount before its deadline")
+
+// DefaultForceCompleteTimeout is how long force-completed contexts have to finish their Defer callbacks.
+const DefaultForceCompleteTimeout = 5 * time.Second
+
+// DefaultUnmountTimeout is how long each provider has to unmount when no other deadline is configured.
+const DefaultUnmountTimeout = 10 * time.Second
+
+// ShutdownPhase identifies a step of the factory shutdown.
+type ShutdownPhase string
+
+const (
+	// PhaseStopAccepting rejects new contexts and closes Done.
+	PhaseStopAccepting ShutdownPhase = "stop-accepting"
+	// PhaseDrain waits for open contexts to complete on their own.
+	PhaseDrain ShutdownPhase = "drain"
+	// PhaseForceComplete completes any contexts still open with ErrShutdownInProgress.
+	PhaseForceComplete ShutdownPhase = "force-complete"
+	// PhaseUnmount calls OnFactoryUnmount on every provider.
+	PhaseUnmount ShutdownPhase = "unmount"
+)
+
+// UnmountTimeoutProvider can be implemented by a Provider that needs its own unmount deadline.
+type UnmountTimeoutProvider interface {
+	UnmountTimeout() time.Duration
+}
+
+// ShutdownConfig controls each phase of ShutdownWithReport.
+type ShutdownConfig struct {
+	// DrainTimeout is how long open contexts, including those of child factories, have to complete on their own.
+	DrainTimeout time.Duration
+	// ForceComplete completes contexts still open after DrainTimeout with ErrShutdownInProgress,
+	// running their Defer callbacks before providers are unmounted.
+	ForceComplete bool
+	// ForceCompleteTimeout bounds how long force-completed contexts have to finish their Defer callbacks,
+	// DefaultForceCompleteTimeout is used if unset.
+	ForceCompleteTimeout time.Duration
+	// UnmountTimeout is the deadline for each provider's OnFactoryUnmount, DefaultUnmountTimeout is used if unset.
+	// Providers implementing UnmountTimeoutProvider override this.
+	UnmountTimeout time.Duration
+}
+
+// ShutdownFailure records a phase (and provider for the unmount phase) that did not finish cleanly.
+type ShutdownFailure struct {
+	Phase ShutdownPhase
+	// Provider is the type name of the provider that failed, empty for non-unmount phases
+	Provider string
+	Err      error
+}
+
+func (s ShutdownFailure) Error() string {
+	if s.Provider != "" {
+		return string(s.Phase) + " (" + s.Provider + "): " + s.Err.Error()
+	}
+	return string(s.Phase) + ": " + s.Err.Error()
+}
+
+func (s ShutdownFailure) Unwrap() error {
+	return s.Err
+}
+
+// ProviderReport describes how a single provider unmounted.
+type ProviderReport struct {
+	Provider string
+	Duration time.Duration
+	Err      error
+}
+
+// ShutdownReport describes the outcome of every phase of a factory shutdown.
+type ShutdownReport struct {
+	FactoryIdentifier string
+	StartedAt         time.Time
+	Duration          time.Duration
+	// OpenContexts is the number of contexts open when the shutdown started.
+	OpenContexts int
+	// Drained is true if every context completed on its own before the drain deadline.
+	Drained bool
+	// ForceCompleted is the number of contexts completed with ErrShutdownInProgress.
+	ForceCompleted int
+	// RemainingContexts is the number of contexts still open once the providers were unmounted.
+	RemainingContexts int
+	Providers         []ProviderReport
+	Failures          []ShutdownFailure
+	// Children are the reports of child factories, which are shut down before this factory drains.
+	Children []ShutdownReport
+}
+
+// Clean is true when every phase of this factory and its children finished without a failure.
+func (s ShutdownReport) Clean() bool {
+	if len(s.Failures) != 0 {
+		return false
+	}
+	for _, child := range s.Children {
+		if !child.Clean() {
+			return false
+		}
+	}
+	return true
+}
+
+// Err joins every failure of this factory and its children into a single error, nil for a clean shutdown.
+func (s ShutdownReport) Err() error {
+	errs := make([]error, 0, len(s.Failures))
+	for _, v := range s.Failures {
+		errs = append(errs, v)
+	}
+	for _, child := range s.Children {
+		if err := child.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", child.FactoryIdentifier, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ShutdownWithReport shuts the factory down in phases:
+// stop accepting new contexts → shut down child factories → drain open contexts → force-complete stragglers
+// (if configured) → unmount providers.
+// Each provider is unmounted in reverse order with its own deadline, so one hung provider can't block the others.
+func (factory *Factory) ShutdownWithReport(config ShutdownConfig) ShutdownReport {
+	startedAt := time.Now()
+	if !factory.stopAccepting() {
+		return ShutdownReport{
+			FactoryIdentifier: factory.factoryIdentifier,
+			StartedAt:         startedAt,
+			Failures: []ShutdownFailure{{
+				Phase: PhaseStopAccepting,
+				Err:   ErrShutdownInProgress,
+			}},
+		}
+	}
+	return factory.shutdownPhases(config, startedAt)
+}
+
+// shutdownPhases runs every phase after new contexts have been rejected.
+func (factory *Factory) shutdownPhases(config ShutdownConfig, startedAt time.Time) ShutdownReport {
+	report := ShutdownReport{
+		FactoryIdentifier: factory.factoryIdentifier,
+		StartedAt:         startedAt,
+		OpenContexts:      factory.OpenContexts(),
+	}
+	if factory.parent != nil {
+		factory.parent.removeChild(factory)
+	}
+	// Children may be using this factory's providers, so they are fully shut down first.
+	// Their drain shares this factory's deadline so nested factories can't stretch the shutdown past DrainTimeout.
+	drainDeadline := startedAt.Add(config.DrainTimeout)
+	report.Children = factory.shutdownChildren(config, drainDeadline)
+	report.Drained = factory.waitForContexts(time.Until(drainDeadline))
+	if !report.Drained {
+		report.Failures = append(report.Failures, ShutdownFailure{
+			Phase: PhaseDrain,
+			Err:   fmt.Errorf("%w: %d contexts still open", ErrDrainTimeout, factory.OpenContexts()),
+		})
+		if config.ForceComplete {
+			forceTimeout := config.ForceCompleteTimeout
+			if forceTimeout <= 0 {
+				forceTimeout = DefaultForceCompleteTimeout
+			}
+			deadline := time.Now().Add(forceTimeout)
+			var panics []error
+			report.ForceCompleted, panics = factory.forceComplete(ErrShutdownInProgress, forceTimeout)
+			for _, err := range panics {
+				report.Failures = append(report.Failures, ShutdownFailure{
+					Phase: PhaseForceComplete,
+					Err:   err,
+				})
+			}
+			// Contexts that were already completing on another goroutine may still be running their callbacks
+			if factory.OpenContexts() > 0 && !factory.waitForContexts(time.Until(deadline)) {
+				report.Failures = append(report.Failures, ShutdownFailure{
+					Phase: PhaseForceComplete,
+					Err:   fmt.Errorf("%d contexts could not be completed", factory.OpenContexts()),
+				})
+			}
+		}
+	}
+	factory.setState(StateUnmounting)
+	unmountTimeout := config.UnmountTimeout
+	if unmountTimeout <= 0 {
+		unmountTimeout = DefaultUnmountTimeout
+	}
+	// Retired providers whose last scene completed are already unmounting, they finish before anything else unmounts
+	factory.addRetirements(&report)
+	factory.defaultsLock.RLock()
+	mounted := factory.providers()
+	providers := make([]*mountedProvider, 0, len(factory.retired)+len(mounted))
+	// Swapped out providers whose scenes never completed are unmounted first
+	providers = append(providers, factory.retired...)
+	// Unmount in reverse order so providers mounted later (which may depend on earlier ones) are released first
+	for k := len(mounted) - 1; k >= 0; k-- {
+		providers = append(providers, mounted[k])
+	}
+	factory.defaultsLock.RUnlock()
+	for _, provider := range providers {
+		if !provider.unmounted.CompareAndSwap(false, true) {
+			continue
+		}
+		providerReport := factory.unmountProvider(provider.Provider, unmountTimeout)
+		report.Providers = append(report.Providers, providerReport)
+		if providerReport.Err != nil {
+			factory.factoryLogger.Error().Str("provider", providerReport.Provider).Err(providerReport.Err).Send()
+			report.Failures = append(report.Failures, ShutdownFailure{
+				Phase:    PhaseUnmount,
+				Provider: providerReport.Provider,
+				Err:      providerReport.Err,
+			})
+		}
+	}
+	// Scenes that completed while providers were unmounting may have started more retirements
+	factory.addRetirements(&report)
+	report.RemainingContexts = factory.OpenContexts()
+	report.Duration = time.Since(startedAt)
+	factory.setState(StateStopped)
+	return report
+}
+
+// addRetirements waits for retired providers unmounting in the background and adds them to the report.
+func (factory *Factory) addRetirements(report *ShutdownReport) {
+	for _, providerReport := range factory.waitForRetirements() {
+		report.Providers = append(report.Providers, providerReport)
+		if providerReport.Err != nil {
+			report.Failures = append(report.Failures, ShutdownFailure{
+				Phase:    PhaseUnmount,
+				Provider: providerReport.Provider,
+				Err:      providerReport.Err,
+			})
+		}
+	}
+}
+
+// waitForContexts waits up to timeout for every open context to complete.
+func (factory *Factory) waitForContexts(timeout time.Duration) bool {
+	c := make(chan struct{})
+	go func() {
+		factory.openContextWg.Wait()
+		close(c)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c:
+		return true
+	case <-timer.C:
+		// The deadline may already have passed (e.g. children used it up), contexts that completed still count
+		return factory.OpenContexts() == 0
+	}
+}
+
+// forceComplete completes every open context with err, returning how many were completed and the recovered panics.
+// Each context completes on its own goroutine and the wait is bounded by timeout,
+// so a hung or panicking Defer callback can't stall or crash the shutdown.
+func (factory *Factory) forceComplete(err error, timeout time.Duration) (int, []error) {
+	type openContext struct {
+		ctx        *context
+		generation uint64
+	}
+	factory.openLock.Lock()
+	open := make([]openContext, 0, len(factory.open))
+	for ctx := range factory.open {
+		// A pooled context may complete and be reused before it is force-completed, so the scene is pinned here
+		open = append(open, openContext{ctx: ctx, generation: ctx.generation.Load()})
+	}
+	factory.openLock.Unlock()
+	// Buffered so goroutines that finish after the deadline don't block
+	panics := make(chan error, len(open))
+	wg := &sync.WaitGroup{}
+	for _, v := range open {
+		wg.Add(1)
+		go func(v openContext) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panics <- fmt.Errorf("panic during force complete: %v", r)
+				}
+			}()
+			v.ctx.completeWithError(v.generation, err)
+		}(v)
+	}
+	completed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(completed)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-completed:
+	case <-timer.C:
+	}
+	var errs []error
+	for {
+		select {
+		case err := <-panics:
+			errs = append(errs, err)
+		default:
+			return len(open), errs
+		}
+	}
+}
+
+func (factory *Factory) unmountProvider(provider Provider, timeout time.Duration) ProviderReport {
+	report := ProviderReport{
+		Provider: fmt.Sprintf("%T", provider),
+	}
+	if timeoutProvider, ok := provider.(UnmountTimeoutProvider); ok {
+		if providerTimeout := timeoutProvider.UnmountTimeout(); providerTimeout > 0 {
+			timeout = providerTimeout
+		}
+	}
+	started := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			// Handle any panics that are recoverable and bubbled up through.
+			if r := recover(); r != nil {
+				result <- fmt.Errorf("panic during unmount: %v", r)
+			}
+		}()
+		result <- provider.OnFactoryUnmount(factory)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case report.Err = <-result:
+	case <-timer.C:
+		report.Err = fmt.Errorf("%w: waited %v", ErrUnmountTimeout, timeout)
+	}
+	report.Duration = time.Since(started)
+	return report
+}
@@ -0,0 +1,216 @@
+package scene_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type unmountProvider struct {
+	scene.BaseProvider
+	name    string
+	order   *[]string
+	err     error
+	block   chan struct{}
+	timeout time.Duration
+	panics  bool
+}
+
+func (u unmountProvider) OnFactoryUnmount(valuer scene.FactoryDefaultValuer) error {
+	if u.block != nil {
+		<-u.block
+	}
+	if u.panics {
+		panic("unmount failed")
+	}
+	*u.order = append(*u.order, u.name)
+	return u.err
+}
+
+func (u unmountProvider) UnmountTimeout() time.Duration {
+	return u.timeout
+}
+
+func TestFactory_ShutdownWithReport(t *testing.T) {
+	newFactory := func(providers ...scene.Provider) *scene.Factory {
+		buf := tsbuffer.New()
+		logger := zerolog.New(buf)
+		factory, _ := scene.NewSceneFactory(scene.Config{
+			FactoryIdentifier: "Test",
+			MaxTTL:            scene.NoTTL,
+			LogOutput:         logger,
+		}, providers...)
+		return factory
+	}
+	t.Run("clean", func(t *testing.T) {
+		var order []string
+		factory := newFactory(unmountProvider{name: "db", order: &order}, nil, unmountProvider{name: "cache", order: &order})
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(time.Millisecond * 10)
+			ctx.Complete()
+		}()
+		report := factory.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Second})
+		require.True(t, report.Clean())
+		require.NoError(t, report.Err())
+		require.True(t, report.Drained)
+		require.Equal(t, 1, report.OpenContexts)
+		require.Equal(t, 0, report.RemainingContexts)
+		require.Equal(t, []string{"cache", "db"}, order)
+		require.Len(t, report.Providers, 2)
+		require.Equal(t, "scene_test.unmountProvider", report.Providers[0].Provider)
+
+		report = factory.ShutdownWithReport(scene.ShutdownConfig{})
+		require.False(t, report.Clean())
+		require.Equal(t, scene.PhaseStopAccepting, report.Failures[0].Phase)
+		require.ErrorIs(t, report.Err(), scene.ErrShutdownInProgress)
+	})
+	t.Run("drain timeout", func(t *testing.T) {
+		var order []string
+		factory := newFactory(unmountProvider{name: "db", order: &order})
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		report := factory.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Millisecond * 10})
+		require.False(t, report.Drained)
+		require.Len(t, report.Failures, 1)
+		require.Equal(t, scene.PhaseDrain, report.Failures[0].Phase)
+		require.ErrorIs(t, report.Err(), scene.ErrDrainTimeout)
+		require.Equal(t, 1, report.RemainingContexts)
+		require.Equal(t, []string{"db"}, order)
+		require.NoError(t, ctx.Err())
+	})
+	t.Run("force complete", func(t *testing.T) {
+		var order []string
+		factory := newFactory(unmountProvider{name: "db", order: &order})
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		ctx.Defer(func(ctx scene.Context, completeErr error) {
+			order = append(order, "defer")
+		})
+		report := factory.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Millisecond * 10, ForceComplete: true})
+		require.False(t, report.Drained)
+		require.Equal(t, 1, report.ForceCompleted)
+		require.Equal(t, 0, report.RemainingContexts)
+		require.ErrorIs(t, ctx.Err(), scene.ErrShutdownInProgress)
+		require.Equal(t, []string{"defer", "db"}, order)
+	})
+	t.Run("provider deadlines", func(t *testing.T) {
+		var order []string
+		block := make(chan struct{})
+		defer close(block)
+		failure := errors.New("close failed")
+		factory := newFactory(
+			unmountProvider{name: "db", order: &order},
+			unmountProvider{name: "hung", order: &order, block: block, timeout: time.Millisecond * 20},
+			unmountProvider{name: "broken", order: &order, err: failure},
+			unmountProvider{name: "panics", order: &order, panics: true},
+		)
+		started := time.Now()
+		report := factory.ShutdownWithReport(scene.ShutdownConfig{DrainTimeout: time.Second, UnmountTimeout: time.Second})
+		require.Less(t, time.Since(started), time.Second)
+		require.True(t, report.Drained)
+		require.Equal(t, []string{"broken", "db"}, order)
+		require.Len(t, report.Failures, 3)
+		for _, failure := range report.Failures {
+			require.Equal(t, scene.PhaseUnmount, failure.Phase)
+		}
+		require.ErrorIs(t, report.Err(), failure)
+		require.ErrorIs(t, report.Err(), scene.ErrUnmountTimeout)
+		require.Contains(t, report.Err().Error(), "panic during unmount")
+	})
+}
+
+func TestFactory_ForceCompleteOnShutdown(t *testing.T) {
+	var order []string
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier:       "Test",
+		MaxTTL:                  scene.NoTTL,
+		LogOutput:               logger,
+		ForceCompleteOnShutdown: true,
+	}, unmountProvider{name: "db", order: &order})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	ctx.Defer(func(ctx scene.Context, completeErr error) {
+		require.ErrorIs(t, completeErr, scene.ErrShutdownInProgress)
+		order = append(order, "defer")
+	})
+	require.False(t, factory.Shutdown(time.Millisecond*10))
+	<-ctx.Done()
+	require.ErrorIs(t, ctx.Err(), scene.ErrShutdownInProgress)
+	require.Equal(t, []string{"defer", "db"}, order)
+	require.Equal(t, 0, factory.OpenContexts())
+}
+
+func TestFactory_ForceCompleteBoundsCallbacks(t *testing.T) {
+	var order []string
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, unmountProvider{name: "db", order: &order})
+	hung, err := factory.NewCtx()
+	require.NoError(t, err)
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		close(release)
+	})
+	hung.Defer(func(ctx scene.Context, completeErr error) {
+		<-release
+	})
+	panics, err := factory.NewCtx()
+	require.NoError(t, err)
+	panics.Defer(func(ctx scene.Context, completeErr error) {
+		panic("broken callback")
+	})
+	started := time.Now()
+	report := factory.ShutdownWithReport(scene.ShutdownConfig{
+		DrainTimeout:         time.Millisecond * 10,
+		ForceComplete:        true,
+		ForceCompleteTimeout: time.Millisecond * 50,
+	})
+	require.Less(t, time.Since(started), time.Second)
+	require.Equal(t, 2, report.ForceCompleted)
+	require.Equal(t, 1, report.RemainingContexts)
+	require.Equal(t, []string{"db"}, order)
+	var phases []scene.ShutdownPhase
+	for _, failure := range report.Failures {
+		phases = append(phases, failure.Phase)
+	}
+	require.Equal(t, []scene.ShutdownPhase{scene.PhaseDrain, scene.PhaseForceComplete, scene.PhaseForceComplete}, phases)
+	require.ErrorContains(t, report.Err(), "broken callback")
+}
+
+func TestFactory_ShutdownWaitsForDeferCallbacks(t *testing.T) {
+	var order []string
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	}, unmountProvider{name: "db", order: &order})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	deferring := make(chan struct{})
+	ctx.Defer(func(ctx scene.Context, completeErr error) {
+		close(deferring)
+		time.Sleep(time.Millisecond * 50)
+		order = append(order, "defer")
+	})
+	go ctx.Complete()
+	<-deferring
+	require.Equal(t, 1, factory.OpenContexts())
+	require.True(t, factory.Shutdown(time.Second))
+	require.Equal(t, []string{"defer", "db"}, order)
+}
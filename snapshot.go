@@ -0,0 +1,305 @@
+package scene
+
+import (
+	"bytes"
+	ogContext "context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var ErrMalformedSnapshot = errors.New("malformed scene snapshot")
+var ErrSnapshotNotJSON = errors.New("codec output is not valid JSON")
+var ErrInvalidExportKey = errors.New("invalid export key")
+
+// LineageKey holds the request IDs a scene was imported from, oldest first.
+type LineageKey struct{}
+
+// snapshotMagic prefixes the binary snapshot format, the last byte is the format version.
+var snapshotMagic = []byte{'S', 'C', 'N', 1}
+
+// Codec converts the value of an exported key to and from bytes.
+type Codec interface {
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte) (any, error)
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec[T]) Unmarshal(data []byte) (any, error) {
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// JSONCodec encodes values as JSON and decodes them into a T, e.g. JSONCodec[string]() for a tenant ID.
+func JSONCodec[T any]() Codec {
+	return jsonCodec[T]{}
+}
+
+type exportKey struct {
+	name  string
+	key   any
+	codec Codec
+}
+
+var exportKeysLock = &sync.RWMutex{}
+
+// Exported keys by their name in a snapshot
+var exportKeys = map[string]*exportKey{}
+
+// RegisterExportKey adds (or replaces) a key that Export includes in snapshots. name identifies the key in a snapshot,
+// so it needs to be the same in every process that imports it.
+// Codecs must produce valid JSON for the key to be exported with ExportJSON.
+// ErrInvalidExportKey is returned for an empty name, a nil codec or a key that can't be used as a map key,
+// and ErrReadOnlyKey for the keys every scene sets itself.
+func RegisterExportKey(name string, key any, codec Codec) error {
+	if name == "" || codec == nil {
+		return fmt.Errorf("%w: a name and codec are required", ErrInvalidExportKey)
+	}
+	if key == nil || !reflect.ValueOf(key).Comparable() {
+		return fmt.Errorf("%w: %T is not comparable", ErrInvalidExportKey, key)
+	}
+	if isReadOnlyKey(key) {
+		return fmt.Errorf("%w: %s", ErrReadOnlyKey, formatKey(key))
+	}
+	exportKeysLock.Lock()
+	defer exportKeysLock.Unlock()
+	for existing, v := range exportKeys {
+		if v.key == key {
+			delete(exportKeys, existing)
+		}
+	}
+	exportKeys[name] = &exportKey{name: name, key: key, codec: codec}
+	return nil
+}
+
+// UnregisterExportKey removes a key registered with RegisterExportKey, e.g. in a test cleanup.
+func UnregisterExportKey(name string) {
+	exportKeysLock.Lock()
+	delete(exportKeys, name)
+	exportKeysLock.Unlock()
+}
+
+// registeredExportKeys gets the registered keys ordered by name, so snapshots are deterministic.
+func registeredExportKeys() []*exportKey {
+	exportKeysLock.RLock()
+	keys := make([]*exportKey, 0, len(exportKeys))
+	for _, v := range exportKeys {
+		keys = append(keys, v)
+	}
+	exportKeysLock.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].name < keys[j].name
+	})
+	return keys
+}
+
+func getExportKey(name string) *exportKey {
+	exportKeysLock.RLock()
+	defer exportKeysLock.RUnlock()
+	return exportKeys[name]
+}
+
+// GetLineage gets the request IDs a scene was imported from, oldest first. nil is returned if it wasn't imported.
+func GetLineage(ctx ogContext.Context) []string {
+	lineage, _ := ctx.Value(LineageKey{}).([]string)
+	return lineage
+}
+
+// snapshot is the format independent content of an exported scene.
+type snapshot struct {
+	Lineage []string
+	Values  map[string][]byte
+}
+
+func takeSnapshot(ctx ogContext.Context) (snapshot, error) {
+	snap := snapshot{Values: map[string][]byte{}}
+	snap.Lineage = append(snap.Lineage, GetLineage(ctx)...)
+	if id := GetRequestID(ctx); id != "" {
+		snap.Lineage = append(snap.Lineage, id)
+	}
+	for _, v := range registeredExportKeys() {
+		value := ctx.Value(v.key)
+		if value == nil {
+			continue
+		}
+		data, err := v.codec.Marshal(value)
+		if err != nil {
+			return snap, fmt.Errorf("export %s: %w", v.name, err)
+		}
+		snap.Values[v.name] = data
+	}
+	return snap, nil
+}
+
+// Export snapshots the registered keys of a scene in a compact binary format, see RegisterExportKey.
+// The scene's request ID is carried as lineage, Factory.Import creates a new scene from the snapshot.
+func Export(ctx ogContext.Context) ([]byte, error) {
+	snap, err := takeSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buf := append([]byte{}, snapshotMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(snap.Lineage)))
+	for _, id := range snap.Lineage {
+		buf = appendBytes(buf, []byte(id))
+	}
+	names := make([]string, 0, len(snap.Values))
+	for name := range snap.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendBytes(buf, []byte(name))
+		buf = appendBytes(buf, snap.Values[name])
+	}
+	return buf, nil
+}
+
+// ExportJSON is Export in a JSON format, for transports that need readable payloads.
+func ExportJSON(ctx ogContext.Context) ([]byte, error) {
+	snap, err := takeSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage, len(snap.Values))
+	for name, data := range snap.Values {
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("export %s: %w", name, ErrSnapshotNotJSON)
+		}
+		values[name] = data
+	}
+	return json.Marshal(jsonSnapshot{Lineage: snap.Lineage, Values: values})
+}
+
+type jsonSnapshot struct {
+	Lineage []string                   `json:"lineage"`
+	Values  map[string]json.RawMessage `json:"values"`
+}
+
+func appendBytes(buf []byte, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)-n) {
+		return nil, nil, ErrMalformedSnapshot
+	}
+	data = data[n:]
+	return data[:size], data[size:], nil
+}
+
+func readCount(data []byte) (int, []byte, error) {
+	count, n := binary.Uvarint(data)
+	// Every entry takes at least a byte, which bounds allocations for corrupt input
+	if n <= 0 || count > uint64(len(data)-n) {
+		return 0, nil, ErrMalformedSnapshot
+	}
+	return int(count), data[n:], nil
+}
+
+// parseSnapshot reads a snapshot in either format.
+func parseSnapshot(data []byte) (snapshot, error) {
+	snap := snapshot{Values: map[string][]byte{}}
+	if !bytes.HasPrefix(data, snapshotMagic) {
+		var decoded jsonSnapshot
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return snap, fmt.Errorf("%w: %w", ErrMalformedSnapshot, err)
+		}
+		snap.Lineage = decoded.Lineage
+		for name, value := range decoded.Values {
+			snap.Values[name] = value
+		}
+		return snap, nil
+	}
+	data = data[len(snapshotMagic):]
+	count, data, err := readCount(data)
+	if err != nil {
+		return snap, err
+	}
+	for i := 0; i < count; i++ {
+		var id []byte
+		if id, data, err = readBytes(data); err != nil {
+			return snap, err
+		}
+		snap.Lineage = append(snap.Lineage, string(id))
+	}
+	if count, data, err = readCount(data); err != nil {
+		return snap, err
+	}
+	for i := 0; i < count; i++ {
+		var name, value []byte
+		if name, data, err = readBytes(data); err != nil {
+			return snap, err
+		}
+		if value, data, err = readBytes(data); err != nil {
+			return snap, err
+		}
+		snap.Values[string(name)] = value
+	}
+	if len(data) > 0 {
+		return snap, ErrMalformedSnapshot
+	}
+	return snap, nil
+}
+
+// Import creates a new scene from a snapshot made by Export or ExportJSON, the format is detected automatically.
+// The new scene gets its own request ID, the exported scene's lineage is available with GetLineage.
+// Imported values are stored after the providers' OnNewContext hooks ran, values for names that are not registered
+// in this process are skipped. A value for a key a provider made read-only (or sealed) can't be stored, the import
+// fails with ErrReadOnlyKey (or ErrSealed) instead.
+func (factory *Factory) Import(data []byte) (Context, error) {
+	snap, err := parseSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	type importedValue struct {
+		name  string
+		key   any
+		value any
+	}
+	values := make([]importedValue, 0, len(snap.Values))
+	for name, raw := range snap.Values {
+		key := getExportKey(name)
+		if key == nil {
+			continue
+		}
+		value, err := key.codec.Unmarshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("import %s: %w", name, err)
+		}
+		values = append(values, importedValue{name: name, key: key.key, value: value})
+	}
+	if factory.closed.Load() {
+		return nil, ErrShutdownInProgress
+	}
+	ctx := factory.newCtx(ogContext.Background(), factory.requestTTL)
+	for _, v := range values {
+		if _, err := ctx.store(ctx.generation.Load(), v.key, v.value); err != nil {
+			err = fmt.Errorf("import %s: %w", v.name, err)
+			ctx.CompleteWithError(err)
+			return nil, err
+		}
+	}
+	if len(snap.Lineage) > 0 {
+		// The lineage is read-only, so it is set like the request ID
+		ctx.mu.Lock()
+		ctx.contextValues[LineageKey{}] = snap.Lineage
+		ctx.mu.Unlock()
+	}
+	return ctx.start(), nil
+}
@@ -0,0 +1,157 @@
+package scene_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+type tenantKey struct{}
+type userKey struct{}
+type unexportedKey struct{}
+type rawKey struct{}
+
+type snapshotUser struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(value any) ([]byte, error) {
+	return []byte(value.(string)), nil
+}
+
+func (rawCodec) Unmarshal(data []byte) (any, error) {
+	return string(data), nil
+}
+
+type readOnlyTenantProvider struct {
+	scene.BaseProvider
+}
+
+func (readOnlyTenantProvider) OnNewContext(ctx scene.Context) {
+	_ = scene.StoreReadOnly(ctx, tenantKey{}, "fixed")
+}
+
+// registerExportKey registers a key for the length of a test, so the global registry doesn't leak into other tests.
+func registerExportKey(t *testing.T, name string, key any, codec scene.Codec) {
+	require.NoError(t, scene.RegisterExportKey(name, key, codec))
+	t.Cleanup(func() {
+		scene.UnregisterExportKey(name)
+	})
+}
+
+func TestExport(t *testing.T) {
+	registerExportKey(t, "tenant", tenantKey{}, scene.JSONCodec[string]())
+	registerExportKey(t, "user", userKey{}, scene.JSONCodec[snapshotUser]())
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	ctx.Store(tenantKey{}, "acme")
+	ctx.Store(userKey{}, snapshotUser{ID: 7, Email: "user@acme.test"})
+	ctx.Store(unexportedKey{}, "secret")
+
+	for name, export := range map[string]func(ctx scene.Context) ([]byte, error){
+		"binary": func(ctx scene.Context) ([]byte, error) {
+			return scene.Export(ctx)
+		},
+		"json": func(ctx scene.Context) ([]byte, error) {
+			return scene.ExportJSON(ctx)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := export(ctx)
+			require.NoError(t, err)
+			imported, err := factory.Import(data)
+			require.NoError(t, err)
+			defer imported.Complete()
+			require.NotEqual(t, scene.GetRequestID(ctx), scene.GetRequestID(imported))
+			require.Equal(t, []string{scene.GetRequestID(ctx)}, scene.GetLineage(imported))
+			require.Equal(t, "acme", imported.Value(tenantKey{}))
+			require.Equal(t, snapshotUser{ID: 7, Email: "user@acme.test"}, imported.Value(userKey{}))
+			require.Nil(t, imported.Value(unexportedKey{}))
+
+			// Lineage grows with every hop
+			data, err = export(imported.With(tenantKey{}, "other"))
+			require.NoError(t, err)
+			hop, err := factory.Import(data)
+			require.NoError(t, err)
+			defer hop.Complete()
+			require.Equal(t, []string{scene.GetRequestID(ctx), scene.GetRequestID(imported)}, scene.GetLineage(hop))
+			require.Equal(t, "other", hop.Value(tenantKey{}))
+		})
+	}
+	t.Run("malformed", func(t *testing.T) {
+		data, err := scene.Export(ctx)
+		require.NoError(t, err)
+		_, err = factory.Import(data[:len(data)-1])
+		require.ErrorIs(t, err, scene.ErrMalformedSnapshot)
+		_, err = factory.Import([]byte("not a snapshot"))
+		require.ErrorIs(t, err, scene.ErrMalformedSnapshot)
+		require.Equal(t, 1, factory.OpenContexts())
+	})
+	t.Run("codecs", func(t *testing.T) {
+		registerExportKey(t, "raw", rawKey{}, rawCodec{})
+		ctx.Store(rawKey{}, "not json")
+		data, err := scene.Export(ctx)
+		require.NoError(t, err)
+		imported, err := factory.Import(data)
+		require.NoError(t, err)
+		defer imported.Complete()
+		require.Equal(t, "not json", imported.Value(rawKey{}))
+		_, err = scene.ExportJSON(ctx)
+		require.ErrorIs(t, err, scene.ErrSnapshotNotJSON)
+	})
+	t.Run("invalid keys", func(t *testing.T) {
+		require.ErrorIs(t, scene.RegisterExportKey("slice", []string{"tenant"}, rawCodec{}), scene.ErrInvalidExportKey)
+		require.ErrorIs(t, scene.RegisterExportKey("nil", nil, rawCodec{}), scene.ErrInvalidExportKey)
+		require.ErrorIs(t, scene.RegisterExportKey("", rawKey{}, rawCodec{}), scene.ErrInvalidExportKey)
+		require.ErrorIs(t, scene.RegisterExportKey("codec", rawKey{}, nil), scene.ErrInvalidExportKey)
+		require.ErrorIs(t, scene.RegisterExportKey("id", scene.RequestIDKey{}, rawCodec{}), scene.ErrReadOnlyKey)
+	})
+	t.Run("read-only keys", func(t *testing.T) {
+		// DebugMode panics on rejected stores, Import reports them as an error instead
+		protected, _ := scene.NewSceneFactory(scene.Config{
+			FactoryIdentifier: "Protected",
+			MaxTTL:            scene.NoTTL,
+			LogOutput:         logger,
+			DebugMode:         true,
+		}, readOnlyTenantProvider{})
+		t.Cleanup(func() {
+			require.True(t, protected.Shutdown(time.Second))
+		})
+		data, err := scene.Export(ctx)
+		require.NoError(t, err)
+		_, err = protected.Import(data)
+		require.ErrorIs(t, err, scene.ErrReadOnlyKey)
+		require.ErrorContains(t, err, "import tenant")
+		require.Equal(t, 0, protected.OpenContexts())
+	})
+	t.Run("shutdown", func(t *testing.T) {
+		closed, _ := scene.NewSceneFactory(scene.Config{
+			FactoryIdentifier: "Closed",
+			MaxTTL:            scene.NoTTL,
+			LogOutput:         logger,
+		})
+		require.True(t, closed.Shutdown(time.Second))
+		data, err := scene.Export(ctx)
+		require.NoError(t, err)
+		_, err = closed.Import(data)
+		require.ErrorIs(t, err, scene.ErrShutdownInProgress)
+	})
+}
@@ -0,0 +1,183 @@
+package scene
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrEventStreamUnsupported = errors.New("response writer does not support event streams")
+var ErrEventStreamClosed = errors.New("event stream closed")
+
+// DefaultHeartbeatInterval is used when EventStreamOptions.HeartbeatInterval is not set.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// Event is a single server-sent event.
+type Event struct {
+	// ID is sent as the event id, clients send the last one they saw back in the Last-Event-ID header.
+	ID string
+	// Event is the event type, when empty clients treat it as "message".
+	Event string
+	// Data is the payload, multi-line data is split across multiple data fields.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// EventStreamOptions configures a server-sent event stream.
+type EventStreamOptions struct {
+	// HeartbeatInterval is how often a comment is sent to keep intermediaries from closing the connection.
+	// A negative interval disables heartbeats.
+	HeartbeatInterval time.Duration
+	// Retry is sent when the stream opens to set the client's reconnection delay.
+	Retry time.Duration
+}
+
+// EventStream writes server-sent events to the response of the scene it was created from.
+// The stream closes automatically when the scene completes or the factory starts shutting down.
+type EventStream struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	lastEventID string
+	mu          *sync.Mutex
+	closed      bool
+	done        chan struct{}
+}
+
+// NewEventStream opens a server-sent event stream on the response of a scene created by the HTTP middleware.
+// Handlers should keep running until Done is closed, the scene's encoder must not be used once the stream is open.
+func NewEventStream(ctx Context, options EventStreamOptions) (*EventStream, error) {
+	w := GetEncoder(ctx).GetWriter()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrEventStreamUnsupported
+	}
+	stream := &EventStream{
+		w:       w,
+		flusher: flusher,
+		mu:      &sync.Mutex{},
+		done:    make(chan struct{}),
+	}
+	if headers, ok := ctx.Value(CtxHTTPHeaderKey{}).(http.Header); ok {
+		stream.lastEventID = headers.Get("Last-Event-ID")
+	}
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Prevent reverse proxies such as nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if options.Retry > 0 {
+		_, _ = w.Write([]byte("retry: " + strconv.FormatInt(options.Retry.Milliseconds(), 10) + "\n\n"))
+	}
+	flusher.Flush()
+	// Close while the scene is completing so nothing is written after the handler chain returns
+	ctx.Defer(func(ctx Context, completeErr error) {
+		stream.Close()
+	})
+	var factoryDone <-chan struct{}
+	if factory := getFactory(ctx); factory != nil {
+		factoryDone = factory.Done()
+	}
+	heartbeat := options.HeartbeatInterval
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+	go stream.monitor(ctx, factoryDone, heartbeat)
+	return stream, nil
+}
+
+func (e *EventStream) monitor(ctx Context, factoryDone <-chan struct{}, heartbeat time.Duration) {
+	var ticks <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-ticks:
+			if err := e.write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			e.Close()
+			return
+		case <-factoryDone:
+			e.Close()
+			return
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// LastEventID is the Last-Event-ID the client sent when reconnecting, empty for new connections.
+func (e *EventStream) LastEventID() string {
+	return e.lastEventID
+}
+
+// Done is closed once the stream has been closed.
+func (e *EventStream) Done() <-chan struct{} {
+	return e.done
+}
+
+// Send writes an event to the client.
+func (e *EventStream) Send(event Event) error {
+	var sb strings.Builder
+	if event.ID != "" {
+		sb.WriteString("id: ")
+		sb.WriteString(sanitizeEventField(event.ID))
+		sb.WriteByte('\n')
+	}
+	if event.Event != "" {
+		sb.WriteString("event: ")
+		sb.WriteString(sanitizeEventField(event.Event))
+		sb.WriteByte('\n')
+	}
+	if event.Retry > 0 {
+		sb.WriteString("retry: ")
+		sb.WriteString(strconv.FormatInt(event.Retry.Milliseconds(), 10))
+		sb.WriteByte('\n')
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\n") {
+		sb.WriteString("data: ")
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	sb.WriteByte('\n')
+	return e.write(sb.String())
+}
+
+// Close stops the stream, it is safe to call multiple times.
+func (e *EventStream) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	close(e.done)
+}
+
+func (e *EventStream) write(data string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrEventStreamClosed
+	}
+	if _, err := e.w.Write([]byte(data)); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+// sanitizeEventField strips line breaks, which would otherwise terminate the field early.
+func sanitizeEventField(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
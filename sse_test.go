@@ -0,0 +1,105 @@
+package scene_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/scene/encoders"
+)
+
+func newSSEMiddleware(t *testing.T, factory *scene.Factory, call func(ctx scene.Context)) *scene.HTTPMiddleware {
+	middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+		return encoders.NewJSONEncoder(request.Header, testWrapper{})
+	}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {})
+	require.NoError(t, err)
+	middleware.Next(testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+		call(scene.GetScene(r.Context()))
+	}})
+	return middleware
+}
+
+func TestEventStream(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second * 5,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	t.Run("events", func(t *testing.T) {
+		var lastEventID string
+		middleware := newSSEMiddleware(t, factory, func(ctx scene.Context) {
+			stream, err := scene.NewEventStream(ctx, scene.EventStreamOptions{Retry: time.Second, HeartbeatInterval: -1})
+			require.NoError(t, err)
+			lastEventID = stream.LastEventID()
+			require.NoError(t, stream.Send(scene.Event{ID: "2", Event: "update", Data: "line1\nline2"}))
+			require.NoError(t, stream.Send(scene.Event{Data: "plain", Retry: 2 * time.Second}))
+			go ctx.Complete()
+			<-stream.Done()
+			require.ErrorIs(t, stream.Send(scene.Event{Data: "late"}), scene.ErrEventStreamClosed)
+		})
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		middleware.ServeHTTP(recorder, req)
+		require.Equal(t, "1", lastEventID)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+		require.True(t, recorder.Flushed)
+		require.Equal(t, "retry: 1000\n\nid: 2\nevent: update\ndata: line1\ndata: line2\n\nretry: 2000\ndata: plain\n\n", recorder.Body.String())
+	})
+	t.Run("heartbeat", func(t *testing.T) {
+		middleware := newSSEMiddleware(t, factory, func(ctx scene.Context) {
+			stream, err := scene.NewEventStream(ctx, scene.EventStreamOptions{HeartbeatInterval: time.Millisecond * 5})
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond * 30)
+			stream.Close()
+		})
+		recorder := httptest.NewRecorder()
+		middleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events", nil))
+		require.True(t, strings.HasPrefix(recorder.Body.String(), ": heartbeat\n\n"))
+	})
+	t.Run("unsupported writer", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		_, err = scene.NewEventStream(ctx, scene.EventStreamOptions{})
+		require.ErrorIs(t, err, scene.ErrEventStreamUnsupported)
+	})
+}
+
+func TestEventStream_FactoryShutdown(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second * 5,
+		LogOutput:         logger,
+	})
+	opened := make(chan struct{})
+	middleware := newSSEMiddleware(t, factory, func(ctx scene.Context) {
+		stream, err := scene.NewEventStream(ctx, scene.EventStreamOptions{})
+		require.NoError(t, err)
+		close(opened)
+		<-stream.Done()
+	})
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+	}()
+	<-opened
+	require.True(t, factory.Shutdown(time.Second))
+	<-served
+}
@@ -0,0 +1,95 @@
+package scene
+
+import (
+	"errors"
+	"net/http"
+)
+
+// StreamErrorTrailer is the HTTP trailer that reports an error which occurred after a stream was committed.
+const StreamErrorTrailer = "X-Stream-Error"
+
+var ErrStreamingNotSupported = errors.New("encoder does not support streaming")
+
+// StreamSource yields the next item of a stream, ok is false once the stream is exhausted.
+// Returning an error terminates the stream and reports the error to the client.
+type StreamSource func(ctx Context) (obj any, ok bool, err error)
+
+// StreamEncoder is implemented by encoders that can write a response incrementally.
+//
+//	Streams stop when the scene's Done channel closes, reporting the scene's error.
+//	If the source fails before the first item, the error is encoded as a normal response with a status code.
+//	Once the first item is written the status is committed, so errors are reported through StreamErrorTrailer
+//	(and any format specific terminator the encoder documents).
+type StreamEncoder interface {
+	ResponseEncoder
+	EncodeStream(ctx Context, source StreamSource) error
+}
+
+// EncodeStream streams source through the scene's encoder.
+func EncodeStream(ctx Context, source StreamSource) error {
+	if encoder, ok := GetEncoder(ctx).(StreamEncoder); ok {
+		return encoder.EncodeStream(ctx, source)
+	}
+	return ErrStreamingNotSupported
+}
+
+// NextStreamItem gets the next item from source unless the scene has already finished.
+// Encoders should use this rather than calling the source directly.
+func NextStreamItem(ctx Context, source StreamSource) (any, bool, error) {
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	default:
+	}
+	return source(ctx)
+}
+
+// StreamErrorStatus returns the status code a stream error should be reported with.
+func StreamErrorStatus(err error) int {
+	if errors.Is(err, ErrTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		return decodeErr.StatusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// StreamFromChannel creates a stream source that reads from ch until it is closed or the scene finishes.
+func StreamFromChannel[T any](ch <-chan T) StreamSource {
+	return func(ctx Context) (any, bool, error) {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case v, ok := <-ch:
+			if !ok {
+				return nil, false, nil
+			}
+			return v, true, nil
+		}
+	}
+}
+
+// StreamFromSlice creates a stream source that yields every item in items.
+func StreamFromSlice[T any](items []T) StreamSource {
+	var idx int
+	return func(ctx Context) (any, bool, error) {
+		if idx >= len(items) {
+			return nil, false, nil
+		}
+		idx++
+		return items[idx-1], true, nil
+	}
+}
+
+// StreamFromIterator adapts a pull style iterator, next should return false once it is exhausted.
+func StreamFromIterator[T any](next func() (T, bool, error)) StreamSource {
+	return func(ctx Context) (any, bool, error) {
+		v, ok, err := next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		return v, true, nil
+	}
+}
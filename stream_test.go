@@ -0,0 +1,132 @@
+package scene_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/scene/encoders"
+)
+
+func TestEncodeStream(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	serve := func(t *testing.T, accept string, source func(ctx scene.Context) scene.StreamSource) (*httptest.ResponseRecorder, error) {
+		var streamErr error
+		middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+			return encoders.NewJSONEncoder(request.Header, testWrapper{})
+		}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {})
+		require.NoError(t, err)
+		middleware.Next(testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+			ctx := scene.GetScene(r.Context())
+			streamErr = scene.EncodeStream(ctx, source(ctx))
+		}})
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		middleware.ServeHTTP(recorder, req)
+		return recorder, streamErr
+	}
+	t.Run("json array", func(t *testing.T) {
+		recorder, err := serve(t, "", func(ctx scene.Context) scene.StreamSource {
+			return scene.StreamFromSlice([]int{1, 2, 3})
+		})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.True(t, recorder.Flushed)
+		require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		require.JSONEq(t, `[1,2,3]`, recorder.Body.String())
+		require.Equal(t, "", recorder.Result().Trailer.Get(scene.StreamErrorTrailer))
+	})
+	t.Run("empty json array", func(t *testing.T) {
+		recorder, err := serve(t, "", func(ctx scene.Context) scene.StreamSource {
+			return scene.StreamFromSlice([]int{})
+		})
+		require.NoError(t, err)
+		require.JSONEq(t, `[]`, recorder.Body.String())
+	})
+	t.Run("ndjson from channel", func(t *testing.T) {
+		recorder, err := serve(t, "application/x-ndjson", func(ctx scene.Context) scene.StreamSource {
+			ch := make(chan string, 2)
+			ch <- "a"
+			ch <- "b"
+			close(ch)
+			return scene.StreamFromChannel(ch)
+		})
+		require.NoError(t, err)
+		require.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "\"a\"\n\"b\"\n", recorder.Body.String())
+	})
+	t.Run("error before first item", func(t *testing.T) {
+		failure := errors.New("failed to load")
+		recorder, err := serve(t, "", func(ctx scene.Context) scene.StreamSource {
+			return scene.StreamFromIterator(func() (int, bool, error) {
+				return 0, false, failure
+			})
+		})
+		require.ErrorIs(t, err, failure)
+		require.Equal(t, http.StatusInternalServerError, recorder.Code)
+		require.Contains(t, recorder.Body.String(), `"statusCode":500`)
+	})
+	t.Run("error mid stream", func(t *testing.T) {
+		failure := errors.New("failed to load")
+		recorder, err := serve(t, "application/x-ndjson", func(ctx scene.Context) scene.StreamSource {
+			var i int
+			return scene.StreamFromIterator(func() (int, bool, error) {
+				i++
+				if i > 2 {
+					return 0, false, failure
+				}
+				return i, true, nil
+			})
+		})
+		require.ErrorIs(t, err, failure)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, failure.Error(), recorder.Result().Trailer.Get(scene.StreamErrorTrailer))
+		var lines []string
+		scanner := bufio.NewScanner(recorder.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		require.Len(t, lines, 3)
+		require.Equal(t, []string{"1", "2"}, lines[:2])
+		require.Contains(t, lines[2], `"statusCode":500`)
+	})
+	t.Run("stops when the scene completes", func(t *testing.T) {
+		recorder, err := serve(t, "", func(ctx scene.Context) scene.StreamSource {
+			ch := make(chan int)
+			go func() {
+				ch <- 1
+				ctx.Complete()
+			}()
+			return scene.StreamFromChannel(ch)
+		})
+		require.ErrorIs(t, err, scene.ErrComplete)
+		require.JSONEq(t, `[1]`, strings.TrimSpace(recorder.Body.String()))
+		require.Equal(t, scene.ErrComplete.Error(), recorder.Result().Trailer.Get(scene.StreamErrorTrailer))
+	})
+	t.Run("encoder without streaming", func(t *testing.T) {
+		ctx, err := factory.NewCtx()
+		require.NoError(t, err)
+		defer ctx.Complete()
+		require.ErrorIs(t, scene.EncodeStream(ctx, scene.StreamFromSlice([]int{1})), scene.ErrStreamingNotSupported)
+	})
+}
@@ -0,0 +1,92 @@
+package scene
+
+import "net/http"
+
+// ResponseState describes the response after a handler in the chain has returned.
+type ResponseState struct {
+	// StatusCode is the last status code written (or set with SetStatusCode), 0 if none has been.
+	StatusCode int
+	// Committed is true once the status line and headers have been sent.
+	Committed bool
+	// Encoded is true once an encoder has finished writing the response.
+	Encoded bool
+}
+
+// TerminationPolicy decides whether the chain stops after a handler returns.
+// Handlers wrapped with AlwaysRun still execute after the chain has stopped.
+type TerminationPolicy func(ctx Context, state ResponseState) bool
+
+// StopOnErrorStatus stops the chain once a 400+ status code has been written.
+func StopOnErrorStatus(ctx Context, state ResponseState) bool {
+	return state.StatusCode >= http.StatusBadRequest
+}
+
+// StopOnRedirect stops the chain once a 3xx status code has been written.
+func StopOnRedirect(ctx Context, state ResponseState) bool {
+	return state.StatusCode >= http.StatusMultipleChoices && state.StatusCode < http.StatusBadRequest
+}
+
+// StopOnCommitted stops the chain once a response has been written, regardless of its status.
+func StopOnCommitted(ctx Context, state ResponseState) bool {
+	return state.Committed
+}
+
+// StopOnSceneComplete stops the chain once the scene has completed or timed out.
+func StopOnSceneComplete(ctx Context, state ResponseState) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// StopOnAny combines policies, stopping the chain when any of them would.
+func StopOnAny(policies ...TerminationPolicy) TerminationPolicy {
+	return func(ctx Context, state ResponseState) bool {
+		for _, policy := range policies {
+			if policy(ctx, state) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// DefaultTerminationPolicy stops the chain on error statuses and redirects.
+var DefaultTerminationPolicy = StopOnAny(StopOnErrorStatus, StopOnRedirect)
+
+// WithTerminationPolicy sets when the chain stops running handlers, DefaultTerminationPolicy is used otherwise.
+func WithTerminationPolicy(policy TerminationPolicy) HTTPOption {
+	return func(options *httpOptions) {
+		options.terminationPolicy = policy
+	}
+}
+
+type alwaysRunHandler struct {
+	http.Handler
+}
+
+// AlwaysRun marks a handler to run even after the chain has been terminated, such as audit logging.
+func AlwaysRun(handler http.Handler) http.Handler {
+	return alwaysRunHandler{Handler: handler}
+}
+
+// runChain runs handlers until the policy terminates the chain, after which only AlwaysRun handlers execute.
+func runChain(ctx Context, writer *capturingWriter, request *http.Request, handlers []http.Handler, policy TerminationPolicy) {
+	if policy == nil {
+		policy = DefaultTerminationPolicy
+	}
+	var terminated bool
+	for _, handler := range handlers {
+		if terminated {
+			if _, ok := handler.(alwaysRunHandler); !ok {
+				continue
+			}
+		}
+		handler.ServeHTTP(writer, request)
+		if !terminated && policy(ctx, writer.state()) {
+			terminated = true
+		}
+	}
+}
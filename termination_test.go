@@ -0,0 +1,81 @@
+package scene_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/scene/encoders"
+)
+
+func TestTerminationPolicy(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := zerolog.New(&buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            time.Second,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	middleware, err := scene.NewHTTPMiddleware(factory, func(ctx scene.Context, request *http.Request) scene.ResponseEncoder {
+		return encoders.NewJSONEncoder(request.Header, testWrapper{})
+	}, func(ctx scene.Context, request *http.Request, encoder scene.ResponseEncoder) {})
+	require.NoError(t, err)
+	var calls []string
+	record := func(name string, call func(writer http.ResponseWriter, r *http.Request)) http.Handler {
+		return testHandler{call: func(writer http.ResponseWriter, r *http.Request) {
+			calls = append(calls, name)
+			if call != nil {
+				call(writer, r)
+			}
+		}}
+	}
+	status := func(name string, code int) http.Handler {
+		return record(name, func(writer http.ResponseWriter, r *http.Request) {
+			writer.WriteHeader(code)
+		})
+	}
+	encode := record("encode", func(writer http.ResponseWriter, r *http.Request) {
+		_ = scene.GetEncoder(scene.GetScene(r.Context())).Encode(nil)
+	})
+	complete := record("complete", func(writer http.ResponseWriter, r *http.Request) {
+		scene.GetScene(r.Context()).Complete()
+	})
+	tests := []struct {
+		name     string
+		policy   scene.TerminationPolicy
+		handlers []http.Handler
+		expected []string
+	}{
+		{"default stops on redirect", nil, []http.Handler{status("not-modified", http.StatusNotModified), record("log", nil)}, []string{"not-modified"}},
+		{"default stops on error", nil, []http.Handler{status("error", http.StatusForbidden), record("log", nil)}, []string{"error"}},
+		{"error status allows redirects", scene.StopOnErrorStatus, []http.Handler{status("not-modified", http.StatusNotModified), record("log", nil)}, []string{"not-modified", "log"}},
+		{"committed", scene.StopOnCommitted, []http.Handler{record("auth", nil), encode, record("log", nil)}, []string{"auth", "encode"}},
+		{"scene complete", scene.StopOnSceneComplete, []http.Handler{complete, record("log", nil)}, []string{"complete"}},
+		{"custom", func(ctx scene.Context, state scene.ResponseState) bool {
+			return state.Encoded
+		}, []http.Handler{status("ok", http.StatusOK), encode, record("log", nil)}, []string{"ok", "encode"}},
+		{"always run", nil, []http.Handler{status("error", http.StatusForbidden), record("log", nil), scene.AlwaysRun(record("audit", nil))}, []string{"error", "audit"}},
+		{"always run without termination", nil, []http.Handler{scene.AlwaysRun(record("audit", nil)), record("log", nil)}, []string{"audit", "log"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls = nil
+			var handler http.Handler
+			if test.policy == nil {
+				handler = middleware.Chain(test.handlers...)
+			} else {
+				handler = middleware.With(scene.WithTerminationPolicy(test.policy)).Chain(test.handlers...)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			require.Equal(t, test.expected, calls)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package scene
+
+import (
+	"errors"
+	"sync"
+)
+
+// valueView overrides values of a Scene (or another view) without changing it.
+type valueView struct {
+	Context
+	mu     *sync.RWMutex
+	values map[any]any
+	// Scoped views keep values stored on them, other views write through to the Scene
+	scoped bool
+}
+
+// newValueView overrides key on parent. Views follow the same rules as Store, so an override of a read-only key
+// (or a key a sealed Scene doesn't allow) is rejected and parent is returned as is.
+func newValueView(parent Context, key, value any) Context {
+	if c, generation, ok := asScene(parent); ok {
+		if err := c.canStore(generation, key); err != nil && !errors.Is(err, ErrComplete) {
+			c.rejectStore(GetRequestID(parent), err)
+			return parent
+		}
+	}
+	return &valueView{
+		Context: parent,
+		mu:      &sync.RWMutex{},
+		values:  map[any]any{key: value},
+	}
+}
+
+// runScoped runs fn with a scoped view of parent.
+func runScoped(parent Context, fn func(ctx Context)) {
+	fn(&valueView{
+		Context: parent,
+		mu:      &sync.RWMutex{},
+		values:  make(map[any]any),
+		scoped:  true,
+	})
+}
+
+func (v *valueView) Value(key any) any {
+	val, _ := v.Lookup(key)
+	return val
+}
+
+func (v *valueView) Lookup(key any) (any, bool) {
+	v.mu.RLock()
+	val, found := v.values[key]
+	v.mu.RUnlock()
+	if found {
+		if _, isDeleted := val.(deleted); isDeleted {
+			return nil, false
+		}
+		return val, true
+	}
+	return v.Context.Lookup(key)
+}
+
+func (v *valueView) Store(key, value any) {
+	if !v.scoped {
+		v.Context.Store(key, value)
+		return
+	}
+	if err := v.setScoped(key, value); err != nil && !errors.Is(err, ErrComplete) {
+		if c, _, ok := asScene(v.Context); ok {
+			c.rejectStore(GetRequestID(v), err)
+		}
+	}
+}
+
+func (v *valueView) TryStore(key, value any) error {
+	if !v.scoped {
+		return v.Context.TryStore(key, value)
+	}
+	if v.Err() != nil {
+		return ErrComplete
+	}
+	return v.setScoped(key, value)
+}
+
+// Delete hides the key for the rest of the scope on scoped views, other views delete it from the Scene.
+func (v *valueView) Delete(key any) {
+	if !v.scoped {
+		v.Context.Delete(key)
+		return
+	}
+	v.Store(key, deleted{})
+}
+
+// setScoped stores a value on a scoped view, scoped values can't override keys the Scene protects.
+func (v *valueView) setScoped(key, value any) error {
+	if c, generation, ok := asScene(v.Context); ok {
+		if err := c.canStore(generation, key); err != nil {
+			return err
+		}
+	}
+	v.mu.Lock()
+	v.values[key] = value
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *valueView) With(key, value any) Context {
+	return newValueView(v, key, value)
+}
+
+func (v *valueView) Scoped(fn func(ctx Context)) {
+	runScoped(v, fn)
+}
+
+func (c *context) With(key, value any) Context {
+	return newValueView(c.self, key, value)
+}
+
+func (c *context) Scoped(fn func(ctx Context)) {
+	runScoped(c.self, fn)
+}
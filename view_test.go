@@ -0,0 +1,92 @@
+package scene_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/scene"
+	"github.com/weisbartb/tsbuffer"
+)
+
+func TestContext_With(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	ctx.Store("tenant", "base")
+	ctx.Store("user", "alice")
+
+	view := ctx.With("tenant", "override")
+	nested := view.With("user", "bob")
+	require.Equal(t, "override", view.Value("tenant"))
+	require.Equal(t, "alice", view.Value("user"))
+	require.Equal(t, "override", nested.Value("tenant"))
+	require.Equal(t, "bob", nested.Value("user"))
+	require.Equal(t, "base", ctx.Value("tenant"))
+	require.Equal(t, scene.GetRequestID(ctx), scene.GetRequestID(nested))
+	require.Equal(t, nested, scene.GetScene(nested))
+
+	// Views share the scene's lifecycle and write through to it
+	nested.Store("written", true)
+	require.Equal(t, true, ctx.Value("written"))
+	_, err = scene.Once(nested, "once", func() (int, error) {
+		return 1, nil
+	})
+	require.NoError(t, err)
+	cached, err := scene.Once(ctx, "once", func() (int, error) {
+		return 2, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, cached)
+	nested.Complete()
+	<-ctx.Done()
+	<-view.Done()
+	require.ErrorIs(t, view.Err(), scene.ErrComplete)
+}
+
+func TestContext_Scoped(t *testing.T) {
+	buf := tsbuffer.New()
+	logger := zerolog.New(buf)
+	factory, _ := scene.NewSceneFactory(scene.Config{
+		FactoryIdentifier: "Test",
+		MaxTTL:            scene.NoTTL,
+		LogOutput:         logger,
+		PoolContexts:      true,
+	})
+	t.Cleanup(func() {
+		require.True(t, factory.Shutdown(time.Second))
+	})
+	ctx, err := factory.NewCtx()
+	require.NoError(t, err)
+	defer ctx.Complete()
+	ctx.Store("level", "info")
+	ctx.Scoped(func(scoped scene.Context) {
+		require.Equal(t, "info", scoped.Value("level"))
+		scoped.Store("level", "debug")
+		scoped.Store("trace", true)
+		require.Equal(t, "debug", scoped.Value("level"))
+		require.Equal(t, "info", ctx.Value("level"))
+		scoped.Scoped(func(inner scene.Context) {
+			inner.Store("level", "trace")
+			require.Equal(t, "trace", inner.Value("level"))
+			require.Equal(t, true, inner.Value("trace"))
+		})
+		require.Equal(t, "debug", scoped.Value("level"))
+		// With on a scoped view writes through to the scope
+		scoped.With("user", "bob").Store("stored", "scope")
+		require.Equal(t, "scope", scoped.Value("stored"))
+	})
+	require.Equal(t, "info", ctx.Value("level"))
+	require.Nil(t, ctx.Value("trace"))
+	require.Nil(t, ctx.Value("stored"))
+}